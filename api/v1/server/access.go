@@ -0,0 +1,199 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+	"gorm.io/gorm"
+)
+
+const (
+	accessKey = "access"
+	bodyKey   = "body"
+)
+
+// access describes with which role the current request accesses a list.
+type access struct {
+	List database.List
+	Role database.Role
+	// Share is set, if the access is granted by a share instead of a membership.
+	Share *database.ListShare
+}
+
+// listIDResolver extracts the ID of the list a request is about.
+type listIDResolver func(c echo.Context) (uuid.UUID, error)
+
+func listIDFromQuery(name string) listIDResolver {
+	return func(c echo.Context) (uuid.UUID, error) {
+		return uuid.Parse(c.QueryParam(name))
+	}
+}
+
+func listIDFromParam(name string) listIDResolver {
+	return func(c echo.Context) (uuid.UUID, error) {
+		return uuid.Parse(c.Param(name))
+	}
+}
+
+// listIDFromBody binds the body to a T and returns its list id given by
+// listID. The bound body is stored in the context and the handler has to get
+// it with bodyFromContext instead of binding the body again, so it works on
+// the very list id access was checked for.
+func listIDFromBody[T any](listID func(*T) uuid.UUID) listIDResolver {
+	return func(c echo.Context) (uuid.UUID, error) {
+		i := new(T)
+		if err := c.Bind(i); err != nil {
+			return uuid.Nil, fmt.Errorf("unable to bind body, %w", err)
+		}
+		c.Set(bodyKey, i)
+		return listID(i), nil
+	}
+}
+
+// bodyFromContext returns the body bound by listIDFromBody.
+func bodyFromContext[T any](c echo.Context) (*T, error) {
+	i, ok := c.Get(bodyKey).(*T)
+	if !ok {
+		return nil, fmt.Errorf("wrong type %T context", c.Get(bodyKey))
+	}
+	return i, nil
+}
+
+// listIDFromEntryParam looks up the list of the entry referenced in the path.
+func (s server) listIDFromEntryParam(name string) listIDResolver {
+	return func(c echo.Context) (uuid.UUID, error) {
+		id, err := uuid.Parse(c.Param(name))
+		if err != nil {
+			return uuid.Nil, err
+		}
+		var e database.Entry
+		if err := s.db.Select("list_id").First(&e, id).Error; err != nil {
+			return uuid.Nil, fmt.Errorf("unable to get entry %v, %w", id, err)
+		}
+		return e.ListID, nil
+	}
+}
+
+// listIDFromDeletedEntryParam looks up the list of the entry referenced in
+// the path including deleted entries.
+func (s server) listIDFromDeletedEntryParam(name string) listIDResolver {
+	return func(c echo.Context) (uuid.UUID, error) {
+		id, err := uuid.Parse(c.Param(name))
+		if err != nil {
+			return uuid.Nil, err
+		}
+		var e database.Entry
+		if err := s.db.Unscoped().Select("list_id").First(&e, id).Error; err != nil {
+			return uuid.Nil, fmt.Errorf("unable to get entry %v, %w", id, err)
+		}
+		return e.ListID, nil
+	}
+}
+
+// resolveAccess determines with which role the current request accesses the
+// list l. The role is empty, if there is no access at all.
+func (s server) resolveAccess(c echo.Context, l database.List) (access, error) {
+	a := access{List: l}
+	user, err := userFromContext(c)
+	if err != nil {
+		return a, err
+	}
+	if user != nil {
+		var m database.ListMember
+		err = s.db.Where("list_id = ? AND user_id = ?", l.ID, user.ID).First(&m).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return a, fmt.Errorf("unable to get membership from database, %w", err)
+		}
+		a.Role = m.Role
+		// The owner always owns the list, even without membership
+		if l.OwnerID != nil && *l.OwnerID == user.ID {
+			a.Role = database.RoleOwner
+		}
+	}
+
+	// Shares redeemed in this session or sent with the request may grant more
+	// than the membership
+	share, err := s.requestShare(c, l)
+	if err != nil {
+		return a, err
+	}
+	if share != nil && !a.Role.Allows(share.Role) {
+		a.Role = share.Role
+		a.Share = share
+	}
+	return a, nil
+}
+
+// listAccessMiddleware only calls next, if the current request has at least
+// the role min on the list returned by resolve. The list and the role are
+// stored in the context and can be retrieved with accessFromContext.
+func (s server) listAccessMiddleware(min database.Role, resolve listIDResolver, next echo.HandlerFunc) echo.HandlerFunc {
+	return s.optionalSessionMiddleware(func(c echo.Context) error {
+		id, err := resolve(c)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return echo.NotFoundHandler(c)
+		}
+		if err != nil {
+			return echo.ErrBadRequest.SetInternal(fmt.Errorf("unable to determine list, %w", err))
+		}
+
+		var l database.List
+		if err := s.db.First(&l, id).Error; err != nil {
+			return echo.NotFoundHandler(c)
+		}
+
+		a, err := s.resolveAccess(c, l)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		if !a.Role.Allows(min) {
+			return echo.ErrForbidden.SetInternal(fmt.Errorf("role %q on list %v, but %q required", a.Role, l.ID, min))
+		}
+		// Every request with a share token counts as use of the share, the
+		// redeemed ones were counted on redemption
+		if _, ok := shareToken(c); ok && a.Share != nil {
+			if err := s.useShare(a.Share); err != nil {
+				return err
+			}
+		}
+
+		c.Set(accessKey, &a)
+		return next(c)
+	})
+}
+
+// recheckAccess resolves the access of the client to the list again for long
+// running streams, because the session, memberships and shares may have
+// changed since the connection was opened. It fails, if the client may not
+// even view the list anymore.
+func (s server) recheckAccess(c echo.Context, listID uuid.UUID) (*access, error) {
+	if sess, ok := c.Get(currentSessionKey).(*database.Session); ok {
+		if err := s.db.First(&database.Session{}, sess.ID).Error; err != nil {
+			return nil, echo.ErrUnauthorized.WithInternal(fmt.Errorf("session %v revoked, %w", sess.ID, err))
+		}
+	}
+	var l database.List
+	if err := s.db.First(&l, listID).Error; err != nil {
+		return nil, echo.ErrNotFound.WithInternal(fmt.Errorf("unable to get list %v, %w", listID, err))
+	}
+	a, err := s.resolveAccess(c, l)
+	if err != nil {
+		return nil, echo.ErrInternalServerError.WithInternal(err)
+	}
+	if !a.Role.Allows(database.RoleViewer) {
+		return nil, echo.ErrForbidden.WithInternal(fmt.Errorf("no access to list %v anymore", l.ID))
+	}
+	return &a, nil
+}
+
+// accessFromContext returns the access set by listAccessMiddleware.
+func accessFromContext(c echo.Context) (*access, error) {
+	a, ok := c.Get(accessKey).(*access)
+	if !ok {
+		return nil, fmt.Errorf("wrong type %T context", c.Get(accessKey))
+	}
+	return a, nil
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/shaardie/listinator/database"
+)
+
+func TestListAccess(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	stranger := env.user("stranger")
+	viewer := env.user("viewer")
+	l, e := env.list(owner)
+
+	var v database.User
+	if err := env.db.First(&v, "name = ?", "viewer").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := env.db.Create(&database.ListMember{ListID: l.ID, UserID: v.ID, Role: database.RoleViewer}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	entry := map[string]any{"Name": "bread", "ListID": l.ID}
+	routes := []struct {
+		method string
+		path   string
+		body   any
+		// viewer tells whether viewers have access
+		viewer bool
+	}{
+		{http.MethodGet, fmt.Sprintf("/api/v1/lists/%v", l.ID), nil, true},
+		{http.MethodPut, fmt.Sprintf("/api/v1/lists/%v", l.ID), map[string]string{"Name": "mine"}, false},
+		{http.MethodDelete, fmt.Sprintf("/api/v1/lists/%v", l.ID), nil, false},
+		{http.MethodGet, fmt.Sprintf("/api/v1/entries?ListID=%v", l.ID), nil, true},
+		{http.MethodPost, "/api/v1/entries", entry, false},
+		{http.MethodGet, fmt.Sprintf("/api/v1/entries/%v", e.ID), nil, true},
+		{http.MethodPut, fmt.Sprintf("/api/v1/entries/%v", e.ID), entry, false},
+		{http.MethodPatch, fmt.Sprintf("/api/v1/entries/%v", e.ID), map[string]string{"Name": "bread"}, false},
+		{http.MethodDelete, fmt.Sprintf("/api/v1/entries/%v", e.ID), nil, false},
+	}
+	for _, r := range routes {
+		t.Run(r.method+" "+r.path, func(t *testing.T) {
+			if rec := env.do(r.method, r.path, r.body, nil); rec.Code != http.StatusForbidden {
+				t.Errorf("anonymous got %v, want 403", rec.Code)
+			}
+			if rec := env.do(r.method, r.path, r.body, stranger); rec.Code != http.StatusForbidden {
+				t.Errorf("non-member got %v, want 403", rec.Code)
+			}
+			rec := env.do(r.method, r.path, r.body, viewer)
+			if r.viewer && rec.Code != http.StatusOK {
+				t.Errorf("viewer got %v, want 200", rec.Code)
+			}
+			if !r.viewer && rec.Code != http.StatusForbidden {
+				t.Errorf("viewer got %v, want 403", rec.Code)
+			}
+		})
+	}
+
+	// Nothing was changed
+	if rec := env.do(http.MethodGet, fmt.Sprintf("/api/v1/entries/%v", e.ID), nil, owner); rec.Code != http.StatusOK {
+		t.Errorf("owner got %v for the entry, want 200", rec.Code)
+	}
+	var got database.List
+	env.decode(env.do(http.MethodGet, fmt.Sprintf("/api/v1/lists/%v", l.ID), nil, owner), &got)
+	if got.Name != l.Name {
+		t.Errorf("list renamed to %q", got.Name)
+	}
+}
+
+func TestListCreateRequiresSession(t *testing.T) {
+	env := newTestEnv(t)
+	if rec := env.do(http.MethodPost, "/api/v1/lists", map[string]string{"Name": "guest"}, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous got %v, want 401", rec.Code)
+	}
+}
+
+func TestOwnerWithoutMembership(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, _ := env.list(owner)
+	if err := env.db.Unscoped().Where("list_id = ?", l.ID).Delete(&database.ListMember{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if rec := env.do(http.MethodDelete, fmt.Sprintf("/api/v1/lists/%v", l.ID), nil, owner); rec.Code != http.StatusOK {
+		t.Errorf("owner got %v, want 200", rec.Code)
+	}
+}
+
+func TestListIDCaseVariants(t *testing.T) {
+	env := newTestEnv(t)
+	victim := env.user("victim")
+	attacker := env.user("attacker")
+	target, _ := env.list(victim)
+	own, _ := env.list(attacker)
+
+	// JSON keys are matched case-insensitively and the last one wins, so the
+	// list id checked has to be the one the handler works on
+	bodies := map[string]string{
+		"/api/v1/entries":       `{"Name": "x", "ListID": "%v", "listid": "%v"}`,
+		"/api/v1/entries/batch": `{"ListID": "%v", "listid": "%v", "Operations": [{"Op": "create", "Entry": {"Name": "x"}}]}`,
+	}
+	for path, body := range bodies {
+		rec := env.do(http.MethodPost, path, json.RawMessage(fmt.Sprintf(body, own.ID, target.ID)), attacker)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%v got %v, want 403", path, rec.Code)
+		}
+	}
+
+	var n int64
+	if err := env.db.Model(&database.Entry{}).Where("list_id = ?", target.ID).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("%v entries in the list of the victim, want 1", n)
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+	"gorm.io/gorm"
+)
+
+// batchLimit is the maximum number of operations in a batch.
+const batchLimit = 500
+
+// batchOperation is an operation of a batch.
+type batchOperation struct {
+	// Op is create, update or delete.
+	Op string
+	// ID is the entry to update or delete.
+	ID uuid.UUID
+	// Entry contains the fields for create and update.
+	Entry entryInput
+}
+
+// batchInput is the body of a batch.
+type batchInput struct {
+	ListID     uuid.UUID        `json:"ListID"`
+	Operations []batchOperation `json:"Operations"`
+}
+
+// batchError prefixes the message of the error err with the index of the
+// failed operation.
+func batchError(idx int, err error) error {
+	var he *echo.HTTPError
+	if !errors.As(err, &he) {
+		return echo.ErrInternalServerError.WithInternal(err)
+	}
+	msg := he.Message
+	// Conflicts carry the current entry, which does not fit in the message
+	if _, ok := msg.(database.Entry); ok {
+		msg = errVersionConflict.Error()
+	}
+	return echo.NewHTTPError(he.Code, fmt.Sprintf("operation %d: %v", idx, msg)).SetInternal(he.Internal)
+}
+
+// entryBatch runs the operations on the entries of a list in a single
+// transaction, so either all or none of them are applied. The subscribers get
+// one entry.batch event per list.
+func (s server) entryBatch() echo.HandlerFunc {
+	// step is a validated operation
+	type step struct {
+		op     string
+		old, e database.Entry
+	}
+	return func(c echo.Context) error {
+		i, err := bodyFromContext[batchInput](c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		if len(i.Operations) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "missing Operations")
+		}
+		if len(i.Operations) > batchLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("more than %d operations", batchLimit))
+		}
+
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		// Validate all operations before changing anything. Entries changed by
+		// earlier operations are remembered, so operations can build on each
+		// other.
+		steps := make([]step, 0, len(i.Operations))
+		current := map[uuid.UUID]database.Entry{}
+		deleted := map[uuid.UUID]bool{}
+		for idx, op := range i.Operations {
+			switch op.Op {
+			case "create":
+				if op.Entry.ListID == uuid.Nil {
+					op.Entry.ListID = a.List.ID
+				}
+				if op.Entry.ListID != a.List.ID {
+					return batchError(idx, echo.NewHTTPError(http.StatusBadRequest, "entries can only be created in the list of the batch"))
+				}
+				if err := op.Entry.validate(); err != nil {
+					return batchError(idx, err)
+				}
+				steps = append(steps, step{op: op.Op, e: newEntry(op.Entry)})
+				continue
+			case "update", "delete":
+			default:
+				return batchError(idx, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown operation %q", op.Op)))
+			}
+
+			if deleted[op.ID] {
+				return batchError(idx, echo.ErrNotFound)
+			}
+			e, ok := current[op.ID]
+			if !ok {
+				if err := s.db.Where("list_id = ?", a.List.ID).First(&e, op.ID).Error; err != nil {
+					return batchError(idx, echo.ErrNotFound.WithInternal(err))
+				}
+			}
+
+			if op.Op == "delete" {
+				steps = append(steps, step{op: op.Op, e: e})
+				deleted[op.ID] = true
+				continue
+			}
+			if op.Entry.ListID == uuid.Nil {
+				op.Entry.ListID = e.ListID
+			}
+			changed, err := s.changeEntry(c, e, op.Entry)
+			if err != nil {
+				return batchError(idx, err)
+			}
+			steps = append(steps, step{op: op.Op, old: e, e: changed})
+			current[op.ID] = changed
+		}
+
+		results := make([]database.Entry, len(steps))
+		_, err = s.transact(func(tx *gorm.DB) ([]event, error) {
+			events := []event{}
+			for idx, st := range steps {
+				var evs []event
+				var err error
+				switch st.op {
+				case "create":
+					evs, err = insertEntry(c, tx, &st.e)
+				case "update":
+					// Nothing changed
+					if st.e.Version == st.old.Version {
+						break
+					}
+					evs, err = saveEntry(c, tx, st.old, st.e)
+				case "delete":
+					evs, err = removeEntry(c, tx, st.e)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("operation %d: %w", idx, err)
+				}
+				results[idx] = st.e
+				events = append(events, evs...)
+			}
+			return newBatchEvents(c, events)
+		})
+		if errors.Is(err, errVersionConflict) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusOK, results)
+	}
+}
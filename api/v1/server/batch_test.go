@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shaardie/listinator/database"
+	"gorm.io/gorm"
+)
+
+// batch sends the operations as batch on the list.
+func (env *testEnv) batch(l database.List, ops []map[string]any, cookie *http.Cookie) int {
+	env.t.Helper()
+	return env.do(http.MethodPost, "/api/v1/entries/batch", map[string]any{"ListID": l.ID, "Operations": ops}, cookie).Code
+}
+
+// entryNames returns the names of the entries of the list.
+func (env *testEnv) entryNames(l database.List) map[string]bool {
+	env.t.Helper()
+	var es []database.Entry
+	if err := env.db.Where("list_id = ?", l.ID).Find(&es).Error; err != nil {
+		env.t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, e := range es {
+		names[e.Name] = e.Bought
+	}
+	return names
+}
+
+func TestBatchRollback(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, e := env.list(owner)
+	before, err := env.s.eventsAfter(l.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Updating entries fails within the transaction after the first
+	// operation was applied
+	fail := true
+	err = env.db.Callback().Update().Before("gorm:update").Register("test:fail", func(tx *gorm.DB) {
+		if fail && tx.Statement.Table == "entries" {
+			tx.AddError(errors.New("disk full"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := []map[string]any{
+		{"Op": "create", "Entry": map[string]any{"Name": "bread"}},
+		{"Op": "update", "ID": e.ID, "Entry": map[string]any{"Name": "milk", "Bought": true}},
+		{"Op": "create", "Entry": map[string]any{"Name": "eggs"}},
+	}
+	if got := env.batch(l, ops, owner); got != http.StatusInternalServerError {
+		t.Fatalf("got %v, want 500", got)
+	}
+	fail = false
+
+	// A failing validation rolls back as well
+	invalid := append(ops[:2:2], map[string]any{"Op": "delete", "ID": l.ID})
+	if got := env.batch(l, invalid, owner); got != http.StatusNotFound {
+		t.Fatalf("got %v, want 404", got)
+	}
+
+	if names := env.entryNames(l); len(names) != 1 || names["milk"] {
+		t.Errorf("list contains %v, want only milk not bought", names)
+	}
+	after, err := env.s.eventsAfter(l.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Errorf("got %v events after failed batches, want %v", len(after), len(before))
+	}
+	var revisions int64
+	if err := env.db.Model(&database.EntryRevision{}).Where("list_id = ?", l.ID).Count(&revisions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if revisions != 1 {
+		t.Errorf("got %v revisions, want only the one of milk", revisions)
+	}
+}
+
+func TestBatchEvent(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, e := env.list(owner)
+	id, ch, err := env.s.eventPubSub.Subscribe(l.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.s.eventPubSub.Unsubscribe(l.ID, id)
+
+	ops := []map[string]any{
+		{"Op": "create", "Entry": map[string]any{"Name": "bread"}},
+		{"Op": "update", "ID": e.ID, "Entry": map[string]any{"Name": "milk", "Bought": true}},
+		{"Op": "delete", "ID": e.ID},
+	}
+	if got := env.batch(l, ops, owner); got != http.StatusOK {
+		t.Fatalf("got %v, want 200", got)
+	}
+	if names := env.entryNames(l); len(names) != 1 || names["bread"] {
+		t.Errorf("list contains %v, want only bread not bought", names)
+	}
+
+	var ev event
+	select {
+	case ev = <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+	if ev.Type != eventEntryBatch {
+		t.Fatalf("got event %v, want %v", ev.Type, eventEntryBatch)
+	}
+	var payload batchPayload
+	if err := json.Unmarshal(ev.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	want := []eventType{eventEntryCreated, eventEntryUpdated, eventEntryDeleted}
+	if len(payload.Events) != len(want) {
+		t.Fatalf("got %v events in batch, want %v", len(payload.Events), len(want))
+	}
+	for i, item := range payload.Events {
+		if item.Type != want[i] {
+			t.Errorf("event %d is %v, want %v", i, item.Type, want[i])
+		}
+	}
+
+	select {
+	case ev := <-ch:
+		t.Errorf("got another event %v", ev.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
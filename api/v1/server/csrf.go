@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const csrfCookieName = "_csrf"
+
+// CSRFMiddleware protects cookie authenticated requests against cross-site
+// request forgery. Browsers sending the Sec-Fetch-Site header are checked
+// with it, all others need to send the value of the CSRF cookie in the
+// X-CSRF-Token header (double-submit cookie).
+// Requests authenticated with an API token or a share token in the header do
+// not rely on cookies and are therefor not affected.
+func CSRFMiddleware() echo.MiddlewareFunc {
+	return middleware.CSRFWithConfig(middleware.CSRFConfig{
+		Skipper: func(c echo.Context) bool {
+			_, ok := bearerToken(c)
+			return ok || c.Request().Header.Get(headerShareToken) != ""
+		},
+		TokenLookup:    "header:" + echo.HeaderXCSRFToken,
+		CookieName:     csrfCookieName,
+		CookiePath:     "/",
+		CookieSecure:   true,
+		CookieSameSite: http.SameSiteStrictMode,
+		// The frontend needs to read the cookie to send it back in the header
+		CookieHTTPOnly: false,
+	})
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	e := echo.New()
+	e.Use(CSRFMiddleware())
+	e.GET("/api/v1/session", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	e.POST("/api/v1/entries", func(c echo.Context) error {
+		return c.NoContent(http.StatusCreated)
+	})
+
+	// Get a token like an old browser without Sec-Fetch-Site support would
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/session", nil))
+	var token string
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == csrfCookieName {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		t.Fatal("no csrf cookie set")
+	}
+
+	form := url.Values{"Name": {"Milk"}}.Encode()
+	tests := []struct {
+		name    string
+		headers map[string]string
+		cookie  bool
+		want    int
+	}{
+		{
+			name: "cross-origin form post",
+			headers: map[string]string{
+				echo.HeaderContentType:  echo.MIMEApplicationForm,
+				echo.HeaderOrigin:       "https://evil.example",
+				echo.HeaderSecFetchSite: "cross-site",
+			},
+			cookie: true,
+			want:   http.StatusForbidden,
+		},
+		{
+			name: "same-site form post without token",
+			headers: map[string]string{
+				echo.HeaderContentType:  echo.MIMEApplicationForm,
+				echo.HeaderOrigin:       "https://evil.listinator.example",
+				echo.HeaderSecFetchSite: "same-site",
+			},
+			cookie: true,
+			want:   http.StatusBadRequest,
+		},
+		{
+			name: "form post without Sec-Fetch-Site and without token",
+			headers: map[string]string{
+				echo.HeaderContentType: echo.MIMEApplicationForm,
+			},
+			cookie: true,
+			want:   http.StatusBadRequest,
+		},
+		{
+			name: "post with wrong token",
+			headers: map[string]string{
+				echo.HeaderXCSRFToken: "wrong",
+			},
+			cookie: true,
+			want:   http.StatusForbidden,
+		},
+		{
+			name: "same-origin post",
+			headers: map[string]string{
+				echo.HeaderSecFetchSite: "same-origin",
+			},
+			cookie: true,
+			want:   http.StatusCreated,
+		},
+		{
+			name: "post with matching token",
+			headers: map[string]string{
+				echo.HeaderXCSRFToken: token,
+			},
+			cookie: true,
+			want:   http.StatusCreated,
+		},
+		{
+			name: "cross-origin post with bearer token",
+			headers: map[string]string{
+				echo.HeaderAuthorization: "Bearer secret",
+				echo.HeaderSecFetchSite:  "cross-site",
+			},
+			want: http.StatusCreated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/entries", strings.NewReader(form))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if tt.cookie {
+				req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("got status %v, want %v", rec.Code, tt.want)
+			}
+		})
+	}
+}
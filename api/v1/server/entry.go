@@ -1,16 +1,21 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 
 	"github.com/shaardie/listinator/database"
+	"gorm.io/gorm"
 )
 
 func (s server) entryList() echo.HandlerFunc {
@@ -35,35 +40,293 @@ func (s server) entryList() echo.HandlerFunc {
 	}
 }
 
-func (s server) entryCreate() echo.HandlerFunc {
-	type input struct {
-		Name   string    `json:"Name"`
-		Number string    `json:"Number"`
-		Bought bool      `json:"Bought"`
-		TypeID uuid.UUID `json:"TypeID"`
-		ListID uuid.UUID `json:"ListID"`
+// entryInput contains the fields of an entry, which can be set by clients.
+type entryInput struct {
+	Name   string    `json:"Name"`
+	Number string    `json:"Number"`
+	Bought bool      `json:"Bought"`
+	TypeID uuid.UUID `json:"TypeID"`
+	ListID uuid.UUID `json:"ListID"`
+	// Version is the version of the entry the changes are based on. If set,
+	// the update fails with a conflict, if the entry was changed since.
+	Version int64 `json:"Version"`
+}
+
+func (i entryInput) validate() error {
+	if strings.TrimSpace(i.Name) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing Name")
 	}
-	return func(c echo.Context) error {
-		var i input
-		if err := c.Bind(&i); err != nil {
-			return echo.ErrBadRequest.SetInternal(err)
+	if i.ListID == uuid.Nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing ListID")
+	}
+	return nil
+}
+
+// errVersionConflict is returned, if an entry was changed or deleted since it
+// was read.
+var errVersionConflict = errors.New("entry was changed in the meantime")
+
+// entryETag returns the ETag of the entry e, which changes with its version.
+func entryETag(e database.Entry) string {
+	return fmt.Sprintf(`"%d"`, e.Version)
+}
+
+// matchETag reports whether the ETag of e is in the If-Match or If-None-Match
+// header value h.
+func matchETag(h string, e database.Entry) bool {
+	etag := entryETag(e)
+	for tag := range strings.SplitSeq(h, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
 		}
+	}
+	return false
+}
+
+// checkIfMatch checks the If-Match header of the request against the entry
+// e and fails with 412 Precondition Failed and the current entry, if the
+// client based its request on another version.
+func (s server) checkIfMatch(c echo.Context, e database.Entry) error {
+	h := c.Request().Header.Get("If-Match")
+	if h == "" || matchETag(h, e) {
+		return nil
+	}
+	return s.entryConflict(c, http.StatusPreconditionFailed, e.ID)
+}
 
-		e := database.Entry{
-			Name:   i.Name,
-			Number: i.Number,
-			Bought: i.Bought,
-			TypeID: i.TypeID,
-			ListID: i.ListID,
+// entryConflict returns an error with the status code and the current entry
+// as body, so the client can merge its changes and try again.
+func (s server) entryConflict(c echo.Context, code int, id uuid.UUID) error {
+	var e database.Entry
+	if err := s.db.First(&e, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return echo.ErrNotFound.WithInternal(errVersionConflict)
 		}
-		if err := s.db.Create(&e).Error; err != nil {
+		return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get entry %v, %w", id, err))
+	}
+	c.Response().Header().Set("ETag", entryETag(e))
+	return echo.NewHTTPError(code, e).SetInternal(errVersionConflict)
+}
+
+// createEntry creates a new entry from i. The caller has to check the
+// permission to edit the list.
+func (s server) createEntry(c echo.Context, i entryInput) (database.Entry, error) {
+	if err := i.validate(); err != nil {
+		return database.Entry{}, err
+	}
+
+	e := newEntry(i)
+	_, err := s.transact(func(tx *gorm.DB) ([]event, error) {
+		return insertEntry(c, tx, &e)
+	})
+	if err != nil {
+		return database.Entry{}, echo.ErrInternalServerError.SetInternal(err)
+	}
+	return e, nil
+}
+
+// updateEntry replaces the fields of the entry e with i. The caller has to
+// check the permission to edit the current list of the entry, moving the
+// entry to another list is checked here.
+func (s server) updateEntry(c echo.Context, e database.Entry, i entryInput) (database.Entry, error) {
+	old := e
+	e, err := s.changeEntry(c, e, i)
+	if err != nil {
+		return database.Entry{}, err
+	}
+	// Nothing to do
+	if e.Version == old.Version {
+		return e, nil
+	}
+
+	_, err = s.transact(func(tx *gorm.DB) ([]event, error) {
+		return saveEntry(c, tx, old, e)
+	})
+	if errors.Is(err, errVersionConflict) {
+		return database.Entry{}, s.entryConflict(c, http.StatusConflict, e.ID)
+	}
+	if err != nil {
+		return database.Entry{}, echo.ErrInternalServerError.SetInternal(err)
+	}
+	return e, nil
+}
+
+// deleteEntry deletes the entry e, if it was not changed since it was read.
+// The caller has to check the permission to edit the list.
+func (s server) deleteEntry(c echo.Context, e database.Entry) error {
+	_, err := s.transact(func(tx *gorm.DB) ([]event, error) {
+		return removeEntry(c, tx, e)
+	})
+	if errors.Is(err, errVersionConflict) {
+		return s.entryConflict(c, http.StatusConflict, e.ID)
+	}
+	if err != nil {
+		return echo.ErrInternalServerError.SetInternal(err)
+	}
+	return nil
+}
+
+func newEntry(i entryInput) database.Entry {
+	e := database.Entry{
+		Name:   i.Name,
+		Bought: i.Bought,
+		TypeID: i.TypeID,
+		ListID: i.ListID,
+	}
+	e.SetNumber(i.Number)
+	return e
+}
+
+// changeEntry validates i and returns the entry e changed by i with the next
+// version. If nothing changes, e is returned as it is. Moving the entry
+// requires the permission to edit the target list, which is checked here.
+func (s server) changeEntry(c echo.Context, e database.Entry, i entryInput) (database.Entry, error) {
+	if err := i.validate(); err != nil {
+		return database.Entry{}, err
+	}
+
+	// Moving an entry requires the permission to edit the target list as well
+	if i.ListID != e.ListID {
+		var l database.List
+		if err := s.db.First(&l, i.ListID).Error; err != nil {
+			return database.Entry{}, echo.ErrBadRequest.SetInternal(fmt.Errorf("unable to get list %v, %w", i.ListID, err))
+		}
+		a, err := s.resolveAccess(c, l)
+		if err != nil {
+			return database.Entry{}, echo.ErrInternalServerError.SetInternal(err)
+		}
+		if !a.Role.Allows(database.RoleEditor) {
+			return database.Entry{}, echo.ErrForbidden.SetInternal(fmt.Errorf("role %q on target list %v", a.Role, l.ID))
+		}
+	}
+
+	if i.Version != 0 && i.Version != e.Version {
+		return database.Entry{}, s.entryConflict(c, http.StatusConflict, e.ID)
+	}
+
+	old := e
+	e.Name = i.Name
+	e.SetNumber(i.Number)
+	e.Bought = i.Bought
+	e.TypeID = i.TypeID
+	e.ListID = i.ListID
+	if len(entryChanges(old, e)) > 0 {
+		e.Version++
+	}
+	return e, nil
+}
+
+// insertEntry creates the entry e within the transaction tx and returns its
+// event.
+func insertEntry(c echo.Context, tx *gorm.DB, e *database.Entry) ([]event, error) {
+	if err := tx.Create(e).Error; err != nil {
+		return nil, fmt.Errorf("unable to create entry, %w", err)
+	}
+	if err := recordRevision(c, tx, revisionCreated, nil, e); err != nil {
+		return nil, err
+	}
+	ev, err := newEntryEvent(c, eventEntryCreated, *e)
+	if err != nil {
+		return nil, err
+	}
+	return []event{ev}, nil
+}
+
+// saveEntry writes the entry e changed from old within the transaction tx and
+// returns its events. It fails with errVersionConflict, if the entry was
+// changed since old was read.
+func saveEntry(c echo.Context, tx *gorm.DB, old, e database.Entry) ([]event, error) {
+	res := tx.Model(&e).Where("version = ?", old.Version).
+		Select("name", "number", "amount", "unit", "bought", "type_id", "list_id", "version", "updated_at").
+		Updates(&e)
+	if res.Error != nil {
+		return nil, fmt.Errorf("unable to update entry %v, %w", e.ID, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return nil, errVersionConflict
+	}
+	if err := recordRevision(c, tx, revisionUpdated, &old, &e); err != nil {
+		return nil, err
+	}
+	if old.ListID == e.ListID {
+		ev, err := newEntryUpdateEvent(c, old, e)
+		if err != nil {
+			return nil, err
+		}
+		return []event{ev}, nil
+	}
+	// The entry is gone for subscribers of the old list
+	deleted, err := newEntryEvent(c, eventEntryDeleted, old)
+	if err != nil {
+		return nil, err
+	}
+	created, err := newEntryEvent(c, eventEntryCreated, e)
+	if err != nil {
+		return nil, err
+	}
+	return []event{deleted, created}, nil
+}
+
+// removeEntry deletes the entry e within the transaction tx and returns its
+// event. It fails with errVersionConflict, if the entry was changed since e
+// was read.
+func removeEntry(c echo.Context, tx *gorm.DB, e database.Entry) ([]event, error) {
+	res := tx.Where("version = ?", e.Version).Delete(&e)
+	if res.Error != nil {
+		return nil, fmt.Errorf("unable to delete entry %v, %w", e.ID, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return nil, errVersionConflict
+	}
+	if err := recordRevision(c, tx, revisionDeleted, &e, nil); err != nil {
+		return nil, err
+	}
+	ev, err := newEntryEvent(c, eventEntryDeleted, e)
+	if err != nil {
+		return nil, err
+	}
+	return []event{ev}, nil
+}
+
+// restoreEntry undeletes the deleted entry e within the transaction tx and
+// returns its event. It fails with errVersionConflict, if the entry was
+// changed since e was read.
+func restoreEntry(c echo.Context, tx *gorm.DB, e database.Entry) (database.Entry, []event, error) {
+	restored := e
+	restored.DeletedAt = gorm.DeletedAt{}
+	restored.Version++
+	res := tx.Unscoped().Model(&restored).Where("version = ? AND deleted_at IS NOT NULL", e.Version).
+		Select("deleted_at", "version", "updated_at").
+		Updates(&restored)
+	if res.Error != nil {
+		return database.Entry{}, nil, fmt.Errorf("unable to restore entry %v, %w", e.ID, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return database.Entry{}, nil, errVersionConflict
+	}
+	if err := recordRevision(c, tx, revisionRestored, &e, &restored); err != nil {
+		return database.Entry{}, nil, err
+	}
+	ev, err := newEntryEvent(c, eventEntryCreated, restored)
+	if err != nil {
+		return database.Entry{}, nil, err
+	}
+	return restored, []event{ev}, nil
+}
+
+func (s server) entryCreate() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		i, err := bodyFromContext[entryInput](c)
+		if err != nil {
 			return echo.ErrInternalServerError.SetInternal(err)
 		}
 
-		s.entryPubSub.Publish(e.ListID, entryEvent{
-			Action: "create",
-			Entry:  e,
-		})
+		e, err := s.createEntry(c, *i)
+		if err != nil {
+			return err
+		}
+		c.Response().Header().Set("ETag", entryETag(e))
 		return c.JSON(http.StatusCreated, e)
 	}
 }
@@ -82,18 +345,18 @@ func (s server) entryGet() echo.HandlerFunc {
 		if err := s.db.First(&e, i.ID).Error; err != nil {
 			return echo.NotFoundHandler(c)
 		}
+		c.Response().Header().Set("ETag", entryETag(e))
+		if h := c.Request().Header.Get("If-None-Match"); h != "" && matchETag(h, e) {
+			return c.NoContent(http.StatusNotModified)
+		}
 		return c.JSON(http.StatusOK, e)
 	}
 }
 
 func (s server) entryUpdate() echo.HandlerFunc {
 	type input struct {
-		ID     uuid.UUID `param:"ID"`
-		Name   string    `json:"Name"`
-		Number string    `json:"Number"`
-		Bought bool      `json:"Bought"`
-		TypeID uuid.UUID `json:"TypeID"`
-		ListID uuid.UUID `json:"ListID"`
+		ID uuid.UUID `param:"ID"`
+		entryInput
 	}
 	return func(c echo.Context) error {
 		var i input
@@ -105,31 +368,44 @@ func (s server) entryUpdate() echo.HandlerFunc {
 		if err := s.db.First(&e, i.ID).Error; err != nil {
 			return echo.NotFoundHandler(c)
 		}
-		e.Name = i.Name
-		e.Number = i.Number
-		e.Bought = i.Bought
-		e.TypeID = i.TypeID
-		e.ListID = i.ListID
 
-		if err := s.db.Save(&e).Error; err != nil {
-			return echo.ErrInternalServerError.SetInternal(err)
+		if err := s.checkIfMatch(c, e); err != nil {
+			return err
+		}
+		if c.Request().Header.Get("If-Match") != "" {
+			i.Version = e.Version
 		}
 
-		s.entryPubSub.Publish(e.ListID, entryEvent{
-			Action: "update",
-			Entry:  e,
-		})
+		e, err := s.updateEntry(c, e, i.entryInput)
+		if err != nil {
+			return err
+		}
+		c.Response().Header().Set("ETag", entryETag(e))
 		return c.JSON(http.StatusOK, e)
 	}
 }
 
-func (s server) entryDelete() echo.HandlerFunc {
+// entryPatch changes only the fields of the entry contained in a JSON Merge
+// Patch (RFC 7386).
+func (s server) entryPatch() echo.HandlerFunc {
 	type input struct {
 		ID uuid.UUID `param:"ID"`
 	}
+	isNull := func(v json.RawMessage) bool {
+		return bytes.Equal(bytes.TrimSpace(v), []byte("null"))
+	}
 	return func(c echo.Context) error {
 		var i input
-		if err := c.Bind(&i); err != nil {
+		if err := (&echo.DefaultBinder{}).BindPathParams(c, &i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+		if mediaType != "application/merge-patch+json" && mediaType != echo.MIMEApplicationJSON {
+			return echo.ErrUnsupportedMediaType
+		}
+		patch := map[string]json.RawMessage{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&patch); err != nil {
 			return echo.ErrBadRequest.SetInternal(err)
 		}
 
@@ -137,33 +413,114 @@ func (s server) entryDelete() echo.HandlerFunc {
 		if err := s.db.First(&e, i.ID).Error; err != nil {
 			return echo.NotFoundHandler(c)
 		}
-		if err := s.db.Delete(&e).Error; err != nil {
-			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to delete entry %v, %w", e, err))
+		if err := s.checkIfMatch(c, e); err != nil {
+			return err
+		}
+
+		// Apply the patch to the current entry
+		in := entryInput{
+			Name:   e.Name,
+			Number: e.Number,
+			Bought: e.Bought,
+			TypeID: e.TypeID,
+			ListID: e.ListID,
+		}
+		for k, v := range patch {
+			var dst any
+			switch k {
+			case "Name":
+				dst = &in.Name
+			case "Number":
+				// Removing the number empties it
+				if isNull(v) {
+					in.Number = ""
+					continue
+				}
+				dst = &in.Number
+			case "Bought":
+				dst = &in.Bought
+			case "TypeID":
+				dst = &in.TypeID
+			case "ListID":
+				dst = &in.ListID
+			case "Version":
+				dst = &in.Version
+			default:
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown field %v", k))
+			}
+			if isNull(v) {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%v can not be removed", k))
+			}
+			if err := json.Unmarshal(v, dst); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid %v", k)).SetInternal(err)
+			}
+		}
+		if c.Request().Header.Get("If-Match") != "" {
+			in.Version = e.Version
+		}
+
+		// The references have to exist
+		if _, ok := patch["TypeID"]; ok {
+			if err := s.db.First(&database.Type{}, in.TypeID).Error; err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "unknown TypeID").SetInternal(err)
+			}
+		}
+		if _, ok := patch["ListID"]; ok {
+			if err := s.db.First(&database.List{}, in.ListID).Error; err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "unknown ListID").SetInternal(err)
+			}
+		}
+
+		e, err := s.updateEntry(c, e, in)
+		if err != nil {
+			return err
 		}
-		s.entryPubSub.Publish(e.ListID, entryEvent{
-			Action: "delete",
-			Entry:  e,
-		})
+		c.Response().Header().Set("ETag", entryETag(e))
 		return c.JSON(http.StatusOK, e)
 	}
 }
 
-type entryEvent struct {
-	Action string
-	Entry  database.Entry
+func (s server) entryDelete() echo.HandlerFunc {
+	type input struct {
+		ID uuid.UUID `param:"ID"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		var e database.Entry
+		if err := s.db.First(&e, i.ID).Error; err != nil {
+			return echo.NotFoundHandler(c)
+		}
+		if err := s.checkIfMatch(c, e); err != nil {
+			return err
+		}
+		if err := s.deleteEntry(c, e); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, e)
+	}
 }
 
 func (s server) entryGetEvents() echo.HandlerFunc {
 	type input struct {
 		ListID uuid.UUID `query:"ListID"`
+		// LastEventID is an alternative to the Last-Event-ID header for
+		// clients, which create a new EventSource on reconnect.
+		LastEventID string `query:"LastEventID"`
 	}
 
-	// sendEvent is a helper function to simplify sending SSE Events
-	sendEvent := func(c echo.Context, event string, data string) error {
+	// sendEvent is a helper function to simplify sending SSE Events.
+	// Events without id do not change the last event id of the client.
+	sendEvent := func(c echo.Context, id string, event string, data string) error {
 		w := c.Response()
 		defer w.Flush()
-		if _, err := fmt.Fprintf(w, "id: %v\n", time.Now().UnixMilli()); err != nil {
-			return err
+		if id != "" {
+			if _, err := fmt.Fprintf(w, "id: %v\n", id); err != nil {
+				return err
+			}
 		}
 		if _, err := fmt.Fprintf(w, "data: %v\n", data); err != nil {
 			return err
@@ -179,25 +536,90 @@ func (s server) entryGetEvents() echo.HandlerFunc {
 
 	// ping just send a ping SSE Event with the current time
 	ping := func(c echo.Context) error {
-		if err := sendEvent(c, "ping", "ping"); err != nil {
+		if err := sendEvent(c, "", "ping", "ping"); err != nil {
 			return fmt.Errorf("failed to send event, %w", err)
 		}
 		return nil
 	}
 
+	// sendResync tells the client to fetch all entries again. It gets no new
+	// id, so it does not try to replay after reconnecting.
+	sendResync := func(c echo.Context, listID uuid.UUID) error {
+		resync, err := newEvent(eventResync, listID, nil, nil)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(resync)
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON, %w", err)
+		}
+		if err := sendEvent(c, "", string(resync.Type), string(data)); err != nil {
+			return fmt.Errorf("unable to send event, %w", err)
+		}
+		return nil
+	}
+
+	// sendEnvelope sends the event envelope ev named after its type. Events
+	// of the event log have their sequence number as id.
+	sendEnvelope := func(c echo.Context, ev event) error {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON, %w", err)
+		}
+		id := ""
+		if ev.Seq > 0 {
+			id = strconv.FormatInt(ev.Seq, 10)
+		}
+		if err := sendEvent(c, id, string(ev.Type), string(data)); err != nil {
+			return fmt.Errorf("unable to send event, %w", err)
+		}
+		return nil
+	}
+
 	return func(c echo.Context) error {
 		var i input
 		if err := c.Bind(&i); err != nil {
 			return echo.ErrBadRequest.SetInternal(err)
 		}
 
-		// subscribe to the pubsub channel for this list
-		id, ch, err := s.entryPubSub.Subscribe(i.ListID)
+		lastEventID := c.Request().Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = i.LastEventID
+		}
+		var lastSeq int64
+		if lastEventID != "" {
+			var err error
+			lastSeq, err = strconv.ParseInt(lastEventID, 10, 64)
+			if err != nil || lastSeq < 0 {
+				return echo.ErrBadRequest.SetInternal(fmt.Errorf("invalid last event id %q", lastEventID))
+			}
+		}
+
+		// subscribe to the pubsub channel for this list before reading the
+		// event log, so no event gets lost in between
+		id, ch, err := s.eventPubSub.Subscribe(i.ListID)
 		if err != nil {
 			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to subscribe, %w", err))
 		}
 		// unsubscribe after return
-		defer s.entryPubSub.Unsubscribe(i.ListID, id)
+		defer s.eventPubSub.Unsubscribe(i.ListID, id)
+
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		// subscribe to the presence of the others before joining, so no
+		// presence event gets lost
+		pid, pch, err := s.presencePubSub.Subscribe(i.ListID)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to subscribe to presence, %w", err))
+		}
+		defer s.presencePubSub.Unsubscribe(i.ListID, pid)
+		self, unwatch, err := s.watch(c, a, id, transportSSE)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		defer unwatch()
 
 		w := c.Response()
 		// set header for SSE
@@ -205,31 +627,129 @@ func (s server) entryGetEvents() echo.HandlerFunc {
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
 
+		// tell the client who else is here
+		snapshot, err := newEvent(eventPresenceSnapshot, i.ListID, nil, s.othersWatching(a, self))
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		if err := sendEnvelope(c, snapshot); err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		// replay the events the client missed
+		if lastEventID != "" {
+			events, err := s.eventsAfter(i.ListID, lastSeq)
+			if errors.Is(err, errEventLogTruncated) {
+				if err := sendResync(c, i.ListID); err != nil {
+					return echo.ErrInternalServerError.SetInternal(err)
+				}
+				return nil
+			}
+			if err != nil {
+				return echo.ErrInternalServerError.SetInternal(err)
+			}
+			for _, ev := range events {
+				if err := sendEnvelope(c, ev); err != nil {
+					return echo.ErrInternalServerError.SetInternal(err)
+				}
+				lastSeq = ev.Seq
+				// the list is gone, there is nothing left to stream
+				if ev.Type == eventListDeleted {
+					return nil
+				}
+			}
+		}
+
 		for {
 			select {
 			// return, if connection is closed from client
 			case <-c.Request().Context().Done():
 				return nil
+			// the server is going away, tell the client when to come back
+			case <-s.shutdown:
+				ev, err := newRestartEvent(i.ListID)
+				if err != nil {
+					return echo.ErrInternalServerError.SetInternal(err)
+				}
+				if _, err := fmt.Fprintf(c.Response(), "retry: %d\n", restartRetry.Milliseconds()); err != nil {
+					return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to send retry, %w", err))
+				}
+				if err := sendEnvelope(c, ev); err != nil {
+					return echo.ErrInternalServerError.SetInternal(err)
+				}
+				return nil
 			// send a ping every 5 seconds to keep the SSE connection. This can probably be less than that
 			case <-time.After(5 * time.Second):
+				// end the stream, if the access was revoked in the meantime
+				if _, err := s.recheckAccess(c, i.ListID); err != nil {
+					return err
+				}
 				if err := ping(c); err != nil {
 					return echo.ErrInternalServerError.SetInternal(fmt.Errorf("failed to ping, %w", err))
 				}
+			// receive the presence of the others and send it to the client
+			case pe, ok := <-pch:
+				if !ok {
+					return echo.ErrInternalServerError.SetInternal(errors.New("disconnected, too slow to keep up with presence"))
+				}
+				if pe.Watcher.ID == self.ID {
+					continue
+				}
+				if _, err := s.recheckAccess(c, i.ListID); err != nil {
+					return err
+				}
+				ev, err := pe.envelope(i.ListID)
+				if err != nil {
+					return echo.ErrInternalServerError.SetInternal(err)
+				}
+				if err := sendEnvelope(c, ev); err != nil {
+					return echo.ErrInternalServerError.SetInternal(err)
+				}
 			// receive data from pubsub channel and send them to the client
-			case ee, ok := <-ch:
+			case ev, ok := <-ch:
 				// channel was closed because we could not keep up (see
-				// pubsub.Publish); end the stream so the client notices and
-				// reconnects with a fresh full sync instead of busy-looping
-				// on the now permanently-ready closed channel.
+				// pubsub.Policy); end the stream so the client notices and
+				// reconnects and replays the missed events instead of
+				// busy-looping on the now permanently-ready closed channel.
 				if !ok {
 					return echo.ErrInternalServerError.SetInternal(errors.New("disconnected, too slow to keep up with events"))
 				}
-				entry, err := json.Marshal(ee.Entry)
-				if err != nil {
-					return echo.ErrInternalServerError.SetInternal(fmt.Errorf("failed to marshal JSON, %w", err))
+				// end the stream, if the access was revoked in the meantime.
+				// The list is gone after its deletion, which is still sent.
+				if ev.Type != eventListDeleted {
+					if _, err := s.recheckAccess(c, i.ListID); err != nil {
+						return err
+					}
+				}
+				// Skip the events sent during the replay and replay the ones
+				// the pubsub lost. Without a last event id, the stream starts
+				// with the first event received.
+				events := []event{ev}
+				if lastEventID != "" {
+					var err error
+					events, err = s.catchUp(ev, lastSeq)
+					if errors.Is(err, errEventLogTruncated) {
+						if err := sendResync(c, i.ListID); err != nil {
+							return echo.ErrInternalServerError.SetInternal(err)
+						}
+						return nil
+					}
+					if err != nil {
+						return echo.ErrInternalServerError.SetInternal(err)
+					}
 				}
-				if err := sendEvent(c, ee.Action, string(entry)); err != nil {
-					return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to send event, %w", err))
+				for _, ev := range events {
+					if err := sendEnvelope(c, ev); err != nil {
+						return echo.ErrInternalServerError.SetInternal(err)
+					}
+					if ev.Seq > 0 {
+						lastSeq = ev.Seq
+						lastEventID = strconv.FormatInt(lastSeq, 10)
+					}
+					// the list is gone, there is nothing left to stream
+					if ev.Type == eventListDeleted {
+						return nil
+					}
 				}
 			}
 		}
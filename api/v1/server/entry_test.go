@@ -0,0 +1,446 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+)
+
+// doRaw sends a request like do, but with a raw body and additional headers.
+func (env *testEnv) doRaw(method, path, body string, header map[string]string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	env.t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	env.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// entryBody returns e as JSON body of an update with the name and version.
+func entryBody(e database.Entry, name string, version int64) string {
+	return fmt.Sprintf(`{"Name":%q,"ListID":%q,"TypeID":%q,"Version":%d}`, name, e.ListID, e.TypeID, version)
+}
+
+func TestEntryVersion(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	_, e := env.list(owner)
+	path := fmt.Sprintf("/api/v1/entries/%v", e.ID)
+	if e.Version != 1 {
+		t.Fatalf("new entry has version %v, want 1", e.Version)
+	}
+
+	// a change increases the version and the ETag
+	rec := env.doRaw(http.MethodPut, path, entryBody(e, "oat milk", 1), nil, owner)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update got %v, want 200", rec.Code)
+	}
+	var updated database.Entry
+	env.decode(rec, &updated)
+	if updated.Version != 2 {
+		t.Errorf("updated entry has version %v, want 2", updated.Version)
+	}
+	if got := rec.Header().Get("ETag"); got != `"2"` {
+		t.Errorf("got ETag %v, want \"2\"", got)
+	}
+
+	// an update without changes keeps it
+	rec = env.doRaw(http.MethodPut, path, entryBody(e, "oat milk", 2), nil, owner)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update without changes got %v, want 200", rec.Code)
+	}
+	env.decode(rec, &updated)
+	if updated.Version != 2 {
+		t.Errorf("unchanged entry has version %v, want 2", updated.Version)
+	}
+
+	// the current entry is returned with the ETag
+	rec = env.do(http.MethodGet, path, nil, owner)
+	if got := rec.Header().Get("ETag"); got != `"2"` {
+		t.Errorf("get returned ETag %v, want \"2\"", got)
+	}
+}
+
+func TestEntryConflict(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string]string
+		// version is the version in the body
+		version int64
+		want    int
+	}{
+		{"stale If-Match", map[string]string{"If-Match": `"1"`}, 0, http.StatusPreconditionFailed},
+		{"stale weak If-Match", map[string]string{"If-Match": `W/"1"`}, 0, http.StatusPreconditionFailed},
+		{"stale body version", nil, 1, http.StatusConflict},
+		{"current If-Match", map[string]string{"If-Match": `"2"`}, 0, http.StatusOK},
+		{"any If-Match", map[string]string{"If-Match": "*"}, 0, http.StatusOK},
+		{"current body version", nil, 2, http.StatusOK},
+		// the header wins over the body
+		{"current If-Match with stale body version", map[string]string{"If-Match": `"2"`}, 1, http.StatusOK},
+		{"without version", nil, 0, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			owner := env.user("owner")
+			_, e := env.list(owner)
+			path := fmt.Sprintf("/api/v1/entries/%v", e.ID)
+
+			// another client changes the entry to version 2
+			if rec := env.doRaw(http.MethodPut, path, entryBody(e, "oat milk", 0), nil, owner); rec.Code != http.StatusOK {
+				t.Fatalf("first update failed with %v", rec.Code)
+			}
+
+			rec := env.doRaw(http.MethodPut, path, entryBody(e, "soy milk", tt.version), tt.header, owner)
+			if rec.Code != tt.want {
+				t.Fatalf("got %v, want %v", rec.Code, tt.want)
+			}
+			var got database.Entry
+			env.decode(rec, &got)
+			if tt.want == http.StatusOK {
+				if got.Name != "soy milk" || got.Version != 3 {
+					t.Errorf("got %v in version %v, want soy milk in version 3", got.Name, got.Version)
+				}
+				return
+			}
+
+			// the conflict contains the copy of the server to merge with
+			if got.ID != e.ID || got.Name != "oat milk" || got.Version != 2 {
+				t.Errorf("got %v in version %v, want oat milk in version 2", got.Name, got.Version)
+			}
+			if etag := rec.Header().Get("ETag"); etag != `"2"` {
+				t.Errorf("got ETag %v, want \"2\"", etag)
+			}
+			// and the entry is unchanged
+			env.decode(env.do(http.MethodGet, path, nil, owner), &got)
+			if got.Name != "oat milk" || got.Version != 2 {
+				t.Errorf("entry changed to %v in version %v", got.Name, got.Version)
+			}
+		})
+	}
+}
+
+func TestEntryDeleteConflict(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	_, e := env.list(owner)
+	path := fmt.Sprintf("/api/v1/entries/%v", e.ID)
+	if rec := env.doRaw(http.MethodPut, path, entryBody(e, "oat milk", 0), nil, owner); rec.Code != http.StatusOK {
+		t.Fatalf("update failed with %v", rec.Code)
+	}
+
+	rec := env.doRaw(http.MethodDelete, path, "", map[string]string{"If-Match": `"1"`}, owner)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("delete of stale entry got %v, want 412", rec.Code)
+	}
+	rec = env.doRaw(http.MethodDelete, path, "", map[string]string{"If-Match": `"2"`}, owner)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete of current entry got %v, want 200", rec.Code)
+	}
+}
+
+// patch sends a JSON Merge Patch of the entry.
+func (env *testEnv) patch(e database.Entry, patch string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	env.t.Helper()
+	header := map[string]string{echo.HeaderContentType: "application/merge-patch+json"}
+	return env.doRaw(http.MethodPatch, fmt.Sprintf("/api/v1/entries/%v", e.ID), patch, header, cookie)
+}
+
+func TestEntryPatch(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, e := env.list(owner)
+	if rec := env.patch(e, `{"Number":"2 l"}`, owner); rec.Code != http.StatusOK {
+		t.Fatalf("patching number failed with %v", rec.Code)
+	}
+
+	// omitted fields stay unchanged
+	rec := env.patch(e, `{"Bought":true}`, owner)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("patching bought failed with %v", rec.Code)
+	}
+	var got database.Entry
+	env.decode(rec, &got)
+	if got.Name != "milk" || got.Number != "2 l" || got.TypeID != e.TypeID || got.ListID != l.ID || !got.Bought {
+		t.Errorf("got %+v, want bought milk with number 2 l", got)
+	}
+
+	// the event only contains the changed fields
+	log, err := env.s.eventsAfter(l.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := log[len(log)-1]
+	if last.Type != eventEntryUpdated {
+		t.Fatalf("got event %v, want %v", last.Type, eventEntryUpdated)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(last.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	keys := slices.Sorted(maps.Keys(payload))
+	if want := []string{"Bought", "ID", "ListID", "UpdatedAt", "Version"}; !slices.Equal(keys, want) {
+		t.Errorf("event contains %v, want %v", keys, want)
+	}
+
+	// removing the number empties it
+	rec = env.patch(e, `{"Number":null}`, owner)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("removing number failed with %v", rec.Code)
+	}
+	env.decode(rec, &got)
+	if got.Number != "" || got.Amount != nil {
+		t.Errorf("got number %q and amount %v after removing it", got.Number, got.Amount)
+	}
+}
+
+func TestEntryPatchInvalid(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	_, e := env.list(owner)
+
+	tests := []struct {
+		name  string
+		patch string
+		want  int
+	}{
+		{"null name", `{"Name":null}`, http.StatusBadRequest},
+		{"null bought", `{"Bought":null}`, http.StatusBadRequest},
+		{"null type", `{"TypeID":null}`, http.StatusBadRequest},
+		{"null list", `{"ListID":null}`, http.StatusBadRequest},
+		{"empty name", `{"Name":" "}`, http.StatusBadRequest},
+		{"unknown field", `{"Amount":2}`, http.StatusBadRequest},
+		{"invalid type", `{"Bought":"yes"}`, http.StatusBadRequest},
+		{"unknown list", `{"ListID":"00000000-0000-0000-0000-000000000001"}`, http.StatusBadRequest},
+		{"no object", `[]`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := env.patch(e, tt.patch, owner); rec.Code != tt.want {
+				t.Errorf("got %v, want %v", rec.Code, tt.want)
+			}
+		})
+	}
+
+	// nothing was changed
+	var got database.Entry
+	env.decode(env.do(http.MethodGet, fmt.Sprintf("/api/v1/entries/%v", e.ID), nil, owner), &got)
+	if got.Name != "milk" || got.Version != e.Version {
+		t.Errorf("entry changed to %+v", got)
+	}
+}
+
+func TestEntryPatchMove(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	other := env.user("other")
+	l, e := env.list(owner)
+	own, _ := env.list(owner)
+	viewed, _ := env.list(other)
+	foreign, _ := env.list(other)
+	rec := env.do(http.MethodPost, fmt.Sprintf("/api/v1/lists/%v/members", viewed.ID), map[string]any{"Name": "owner", "Role": database.RoleViewer}, other)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("adding member failed with %v", rec.Code)
+	}
+
+	for name, target := range map[string]database.List{"viewer": viewed, "no member": foreign} {
+		t.Run(name, func(t *testing.T) {
+			rec := env.patch(e, fmt.Sprintf(`{"ListID":%q}`, target.ID), owner)
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("got %v, want 403", rec.Code)
+			}
+			var es []database.Entry
+			env.decode(env.do(http.MethodGet, "/api/v1/entries?ListID="+target.ID.String(), nil, other), &es)
+			if len(es) != 1 {
+				t.Errorf("target list contains %v entries, want 1", len(es))
+			}
+		})
+	}
+
+	rec = env.patch(e, fmt.Sprintf(`{"ListID":%q}`, own.ID), owner)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("moving to own list got %v, want 200", rec.Code)
+	}
+	var es []database.Entry
+	env.decode(env.do(http.MethodGet, "/api/v1/entries?ListID="+l.ID.String(), nil, owner), &es)
+	if len(es) != 0 {
+		t.Errorf("source list contains %v entries after move, want 0", len(es))
+	}
+}
+
+// sseEvent is an event received from an SSE stream.
+type sseEvent struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// openStream opens the SSE stream of the list with the session cookie and
+// the headers and returns the received events and a function closing the
+// stream.
+func openStream(t *testing.T, srv *httptest.Server, listID uuid.UUID, cookie *http.Cookie, header map[string]string) (chan sseEvent, func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%v/api/v1/entries/events?ListID=%v", srv.URL, listID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookie)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		t.Fatalf("stream got %v, want 200", resp.StatusCode)
+	}
+
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		var ev sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			field, value, _ := strings.Cut(scanner.Text(), ": ")
+			switch field {
+			case "id":
+				ev.ID = value
+			case "event":
+				ev.Event = value
+			case "data":
+				ev.Data = value
+			case "":
+				events <- ev
+				ev = sseEvent{}
+			}
+		}
+	}()
+	return events, cancel
+}
+
+// nextEvent returns the next event of the stream except pings or fails after
+// two seconds.
+func nextEvent(t *testing.T, events chan sseEvent) sseEvent {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatal("stream closed")
+			}
+			if ev.Event != "ping" {
+				return ev
+			}
+		case <-timeout:
+			t.Fatal("timeout while waiting for event")
+		}
+	}
+}
+
+// noEvent fails, if the stream sends another event than a ping within a
+// short time.
+func noEvent(t *testing.T, events chan sseEvent) {
+	t.Helper()
+	timeout := time.After(200 * time.Millisecond)
+	for {
+		select {
+		case ev, ok := <-events:
+			if ok && ev.Event != "ping" {
+				t.Errorf("got unexpected event %v %v", ev.ID, ev.Event)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func TestEventStreamReconnect(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, e := env.list(owner)
+	srv := httptest.NewServer(env.e)
+	// closed after the streams
+	t.Cleanup(srv.Close)
+	path := fmt.Sprintf("/api/v1/entries/%v", e.ID)
+	update := func(number string) {
+		t.Helper()
+		if rec := env.do(http.MethodPatch, path, map[string]any{"Number": number}, owner); rec.Code != http.StatusOK {
+			t.Fatalf("updating entry failed with %v", rec.Code)
+		}
+	}
+
+	events, closeStream := openStream(t, srv, l.ID, owner, nil)
+	if ev := nextEvent(t, events); ev.Event != string(eventPresenceSnapshot) || ev.ID != "" {
+		t.Fatalf("got %v %v as first event, want presence.snapshot without id", ev.ID, ev.Event)
+	}
+	update("1")
+	seen := nextEvent(t, events)
+	if seen.Event != string(eventEntryUpdated) || seen.ID == "" {
+		t.Fatalf("got %v %v, want entry.updated with id", seen.ID, seen.Event)
+	}
+	closeStream()
+
+	// the changes while disconnected
+	update("2")
+	update("3")
+
+	events, _ = openStream(t, srv, l.ID, owner, map[string]string{"Last-Event-ID": seen.ID})
+	if ev := nextEvent(t, events); ev.Event != string(eventPresenceSnapshot) {
+		t.Fatalf("got %v after reconnecting, want presence.snapshot", ev.Event)
+	}
+	last, err := strconv.ParseInt(seen.ID, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, number := range []string{"2", "3"} {
+		last++
+		ev := nextEvent(t, events)
+		if ev.Event != string(eventEntryUpdated) || ev.ID != strconv.FormatInt(last, 10) {
+			t.Fatalf("got %v %v, want entry.updated %v", ev.ID, ev.Event, last)
+		}
+		var envelope event
+		if err := json.Unmarshal([]byte(ev.Data), &envelope); err != nil {
+			t.Fatal(err)
+		}
+		var p entryPayload
+		if err := json.Unmarshal(envelope.Payload, &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Number != number {
+			t.Errorf("got number %q, want %q", p.Number, number)
+		}
+	}
+	// exactly the missed events
+	noEvent(t, events)
+
+	// and the live ones afterwards
+	update("4")
+	if ev := nextEvent(t, events); ev.ID != strconv.FormatInt(last+1, 10) {
+		t.Errorf("got %v %v, want live event %v", ev.ID, ev.Event, last+1)
+	}
+}
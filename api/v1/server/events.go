@@ -0,0 +1,460 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+	"github.com/shaardie/listinator/pubsub"
+	"github.com/shaardie/listinator/quantity"
+	"gorm.io/gorm"
+)
+
+// eventLogSize is the number of events kept in the event log of each list.
+// Clients missing more events than that need to resync.
+const eventLogSize = 1000
+
+// errEventLogTruncated is returned, if events are requested, which are not in
+// the event log anymore.
+var errEventLogTruncated = errors.New("event log truncated")
+
+// eventVersion is the version of the event envelope and the payloads. It is
+// increased on incompatible changes, see api/v1/events.schema.json.
+const eventVersion = 1
+
+// eventType is the type of an event. The SSE stream uses it as event name.
+type eventType string
+
+const (
+	eventEntryCreated eventType = "entry.created"
+	eventEntryUpdated eventType = "entry.updated"
+	eventEntryDeleted eventType = "entry.deleted"
+	eventListRenamed  eventType = "list.renamed"
+	eventListDeleted  eventType = "list.deleted"
+	eventTypeChanged  eventType = "type.changed"
+	eventMemberAdded  eventType = "member.added"
+	// eventEntryBatch contains the entry events of a batch, which are applied
+	// at once.
+	eventEntryBatch eventType = "entry.batch"
+
+	// The presence events are not written to the event log.
+	eventPresenceSnapshot eventType = "presence.snapshot"
+	eventPresenceJoined   eventType = "presence.joined"
+	eventPresenceLeft     eventType = "presence.left"
+
+	// eventResync tells the client, that it missed events, which can not be
+	// replayed anymore.
+	eventResync eventType = "resync"
+	// eventServerRestarting tells the client, that the server is shutting
+	// down and it should reconnect after the time in the payload.
+	eventServerRestarting eventType = "server.restarting"
+)
+
+// restartRetry is the time after which clients should reconnect, when the
+// server is shutting down.
+const restartRetry = 3 * time.Second
+
+// event is the envelope of all events streamed to the clients.
+type event struct {
+	Version int
+	Type    eventType
+	ListID  uuid.UUID
+	// Seq is the sequence number of the event in the event log of the list.
+	// Events, which are not written to the log, have none.
+	Seq int64 `json:",omitempty"`
+	// ActorID is the user causing the event, empty for anonymous users.
+	ActorID *uuid.UUID
+	Time    time.Time
+	Payload json.RawMessage `json:",omitempty"`
+
+	// fromSeq is the sequence number of the first event merged into this one
+	// by pubsub.Coalesce, zero if nothing was merged.
+	fromSeq int64
+}
+
+// firstSeq returns the sequence number of the first event this event stands
+// for.
+func (ev event) firstSeq() int64 {
+	if ev.fromSeq > 0 {
+		return ev.fromSeq
+	}
+	return ev.Seq
+}
+
+// newEvent creates an event of type t for the list caused by actor.
+func newEvent(t eventType, listID uuid.UUID, actor *uuid.UUID, payload any) (event, error) {
+	ev := event{
+		Version: eventVersion,
+		Type:    t,
+		ListID:  listID,
+		ActorID: actor,
+		Time:    time.Now(),
+	}
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return event{}, fmt.Errorf("failed to marshal JSON, %w", err)
+		}
+		ev.Payload = b
+	}
+	return ev, nil
+}
+
+// actorID returns the ID of the user of the request or nil for anonymous
+// users.
+func actorID(c echo.Context) *uuid.UUID {
+	user, err := userFromContext(c)
+	if err != nil || user == nil {
+		return nil
+	}
+	return &user.ID
+}
+
+// entryPayload is the payload of the entry events.
+type entryPayload struct {
+	ID        uuid.UUID
+	Name      string
+	Number    string
+	Amount    *float64
+	Unit      quantity.Unit
+	Bought    bool
+	TypeID    uuid.UUID
+	ListID    uuid.UUID
+	Version   int64
+	UpdatedAt time.Time
+}
+
+func newEntryPayload(e database.Entry) entryPayload {
+	return entryPayload{
+		ID:        e.ID,
+		Name:      e.Name,
+		Number:    e.Number,
+		Amount:    e.Amount,
+		Unit:      e.Unit,
+		Bought:    e.Bought,
+		TypeID:    e.TypeID,
+		ListID:    e.ListID,
+		Version:   e.Version,
+		UpdatedAt: e.UpdatedAt,
+	}
+}
+
+// newEntryEvent creates an entry event for the entry e.
+func newEntryEvent(c echo.Context, t eventType, e database.Entry) (event, error) {
+	return newEvent(t, e.ListID, actorID(c), newEntryPayload(e))
+}
+
+// entryChanges returns the names of the fields clients can change, which
+// differ between the entries a and b.
+func entryChanges(a, b database.Entry) []string {
+	changed := []string{}
+	if a.Name != b.Name {
+		changed = append(changed, "Name")
+	}
+	if a.Number != b.Number {
+		changed = append(changed, "Number")
+	}
+	if a.Bought != b.Bought {
+		changed = append(changed, "Bought")
+	}
+	if a.TypeID != b.TypeID {
+		changed = append(changed, "TypeID")
+	}
+	if a.ListID != b.ListID {
+		changed = append(changed, "ListID")
+	}
+	return changed
+}
+
+// newEntryUpdateEvent creates an entry.updated event, which only contains the
+// fields changed from old to e besides ID, ListID, Version and UpdatedAt.
+func newEntryUpdateEvent(c echo.Context, old, e database.Entry) (event, error) {
+	b, err := json.Marshal(newEntryPayload(e))
+	if err != nil {
+		return event{}, fmt.Errorf("failed to marshal JSON, %w", err)
+	}
+	full := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &full); err != nil {
+		return event{}, fmt.Errorf("failed to unmarshal JSON, %w", err)
+	}
+	keys := append([]string{"ID", "ListID", "Version", "UpdatedAt"}, entryChanges(old, e)...)
+	// The quantity is parsed from the number
+	if slices.Contains(keys, "Number") {
+		keys = append(keys, "Amount", "Unit")
+	}
+	payload := map[string]json.RawMessage{}
+	for _, k := range keys {
+		payload[k] = full[k]
+	}
+	return newEvent(eventEntryUpdated, e.ListID, actorID(c), payload)
+}
+
+// mergePayloads applies the fields of the JSON object patch to the JSON
+// object payload.
+func mergePayloads(payload, patch json.RawMessage) (json.RawMessage, error) {
+	m := map[string]json.RawMessage{}
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON, %w", err)
+	}
+	p := map[string]json.RawMessage{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON, %w", err)
+	}
+	maps.Copy(m, p)
+	return json.Marshal(m)
+}
+
+// batchItem is an event of a batch without the envelope.
+type batchItem struct {
+	Type    eventType
+	Payload json.RawMessage
+}
+
+// batchPayload is the payload of the entry.batch event.
+type batchPayload struct {
+	Events []batchItem
+}
+
+// newBatchEvents combines the events into one entry.batch event per list in
+// the order the lists appear.
+func newBatchEvents(c echo.Context, events []event) ([]event, error) {
+	listIDs := []uuid.UUID{}
+	items := map[uuid.UUID][]batchItem{}
+	for _, ev := range events {
+		if _, ok := items[ev.ListID]; !ok {
+			listIDs = append(listIDs, ev.ListID)
+		}
+		items[ev.ListID] = append(items[ev.ListID], batchItem{Type: ev.Type, Payload: ev.Payload})
+	}
+
+	batches := make([]event, 0, len(listIDs))
+	for _, id := range listIDs {
+		ev, err := newEvent(eventEntryBatch, id, actorID(c), batchPayload{Events: items[id]})
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, ev)
+	}
+	return batches, nil
+}
+
+// listPayload is the payload of the list events.
+type listPayload struct {
+	ID   uuid.UUID
+	Name string
+}
+
+// restartPayload is the payload of the server.restarting event.
+type restartPayload struct {
+	// Retry is the time in milliseconds after which the client should
+	// reconnect.
+	Retry int64
+}
+
+// newRestartEvent creates the server.restarting event for the list.
+func newRestartEvent(listID uuid.UUID) (event, error) {
+	return newEvent(eventServerRestarting, listID, nil, restartPayload{Retry: restartRetry.Milliseconds()})
+}
+
+// typePayload is the payload of the type.changed event.
+type typePayload struct {
+	ID       uuid.UUID
+	Name     string
+	Color    string
+	Priority int
+}
+
+// mergeEvents merges two events of the same entry for pubsub.Coalesce.
+// Clients only need the latest state of an entry, as long as they learn
+// about its creation and deletion.
+func mergeEvents(queued, ev event) (event, bool) {
+	if queued.ListID != ev.ListID {
+		return event{}, false
+	}
+	switch {
+	case queued.Type == eventEntryCreated && ev.Type == eventEntryUpdated:
+	case queued.Type == eventEntryUpdated && (ev.Type == eventEntryUpdated || ev.Type == eventEntryDeleted):
+	default:
+		return event{}, false
+	}
+
+	var q, e entryPayload
+	if json.Unmarshal(queued.Payload, &q) != nil || json.Unmarshal(ev.Payload, &e) != nil || q.ID != e.ID {
+		return event{}, false
+	}
+	// Updates only contain the changed fields, so they are applied to the
+	// queued payload
+	if ev.Type == eventEntryUpdated {
+		payload, err := mergePayloads(queued.Payload, ev.Payload)
+		if err != nil {
+			return event{}, false
+		}
+		ev.Payload = payload
+	}
+	if queued.Type == eventEntryCreated {
+		ev.Type = eventEntryCreated
+	}
+	ev.fromSeq = queued.firstSeq()
+	return ev, true
+}
+
+// transact runs fn in a transaction and publishes the events returned by fn
+// after the transaction is committed. The events are written to the event log
+// of their list in the same transaction and get their sequence numbers there.
+//
+// Publishing is serialized, so subscribers get the events of a list in the
+// order of their sequence numbers.
+func (s server) transact(fn func(tx *gorm.DB) ([]event, error)) ([]event, error) {
+	s.eventMu.Lock()
+	defer s.eventMu.Unlock()
+
+	var events []event
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		events, err = fn(tx)
+		if err != nil {
+			return err
+		}
+		for i := range events {
+			if err := appendEvent(tx, &events[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ev := range events {
+		s.eventPubSub.Publish(ev.ListID, ev)
+	}
+	return events, nil
+}
+
+// appendEvent writes the event to the event log of its list, sets its
+// sequence number and truncates the log to eventLogSize.
+func appendEvent(tx *gorm.DB, ev *event) error {
+	var last int64
+	if err := tx.Model(&database.ListEvent{}).Where("list_id = ?", ev.ListID).Select("COALESCE(MAX(seq), 0)").Scan(&last).Error; err != nil {
+		return fmt.Errorf("unable to get last sequence number, %w", err)
+	}
+	ev.Seq = last + 1
+
+	le := database.ListEvent{
+		ListID:    ev.ListID,
+		Seq:       ev.Seq,
+		CreatedAt: ev.Time,
+		Version:   ev.Version,
+		Type:      string(ev.Type),
+		ActorID:   ev.ActorID,
+		Payload:   string(ev.Payload),
+	}
+	if err := tx.Create(&le).Error; err != nil {
+		return fmt.Errorf("unable to append event to log, %w", err)
+	}
+
+	if err := tx.Where("list_id = ? AND seq <= ?", ev.ListID, ev.Seq-eventLogSize).Delete(&database.ListEvent{}).Error; err != nil {
+		return fmt.Errorf("unable to truncate event log, %w", err)
+	}
+	return nil
+}
+
+// eventsAfter returns all events of the list after the sequence number seq.
+// It returns errEventLogTruncated, if some of them are not in the log anymore.
+func (s server) eventsAfter(listID uuid.UUID, seq int64) ([]event, error) {
+	var bounds struct {
+		First int64
+		Last  int64
+	}
+	if err := s.db.Model(&database.ListEvent{}).
+		Where("list_id = ?", listID).
+		Select("COALESCE(MIN(seq), 0) AS first, COALESCE(MAX(seq), 0) AS last").
+		Scan(&bounds).Error; err != nil {
+		return nil, fmt.Errorf("unable to get bounds of event log, %w", err)
+	}
+	// The client knows about events, which do not exist. Probably the
+	// database was reset.
+	if seq > bounds.Last {
+		return nil, errEventLogTruncated
+	}
+	if seq == bounds.Last {
+		return nil, nil
+	}
+	if seq < bounds.First-1 {
+		return nil, errEventLogTruncated
+	}
+
+	les := []database.ListEvent{}
+	if err := s.db.Where("list_id = ? AND seq > ?", listID, seq).Order("seq asc").Find(&les).Error; err != nil {
+		return nil, fmt.Errorf("unable to get events from log, %w", err)
+	}
+	events := make([]event, 0, len(les))
+	for _, le := range les {
+		events = append(events, eventFromLog(le))
+	}
+	return events, nil
+}
+
+// catchUp returns the events to send to a client, which got all events of the
+// list up to lastSeq, when it receives ev from the pubsub. Events it already
+// got are skipped and events lost in between, e.g. dropped because the client
+// was too slow, are replayed from the event log. It returns
+// errEventLogTruncated, if the client has to resync.
+func (s server) catchUp(ev event, lastSeq int64) ([]event, error) {
+	if ev.Seq == 0 {
+		return []event{ev}, nil
+	}
+	if ev.Seq <= lastSeq {
+		return nil, nil
+	}
+	if ev.firstSeq() <= lastSeq+1 {
+		return []event{ev}, nil
+	}
+	return s.eventsAfter(ev.ListID, lastSeq)
+}
+
+func eventFromLog(le database.ListEvent) event {
+	return event{
+		Version: le.Version,
+		Type:    eventType(le.Type),
+		ListID:  le.ListID,
+		Seq:     le.Seq,
+		ActorID: le.ActorID,
+		Time:    le.CreatedAt,
+		Payload: json.RawMessage(le.Payload),
+	}
+}
+
+// lastEventID returns the id of the newest event in the event log of all lists.
+func lastEventID(db *gorm.DB) (int64, error) {
+	var id int64
+	if err := db.Model(&database.ListEvent{}).Select("COALESCE(MAX(id), 0)").Scan(&id).Error; err != nil {
+		return 0, fmt.Errorf("unable to get last event id, %w", err)
+	}
+	return id, nil
+}
+
+// pollEvents returns a pubsub.Source for the event log of all lists. This way
+// events written by other instances sharing the database reach the
+// subscribers of this instance.
+func pollEvents(db *gorm.DB) pubsub.Source[uuid.UUID, event] {
+	return func(cursor int64) ([]pubsub.Message[uuid.UUID, event], int64, error) {
+		les := []database.ListEvent{}
+		if err := db.Where("id > ?", cursor).Order("id asc").Find(&les).Error; err != nil {
+			return nil, cursor, fmt.Errorf("unable to get events from log, %w", err)
+		}
+		msgs := make([]pubsub.Message[uuid.UUID, event], 0, len(les))
+		for _, le := range les {
+			cursor = le.ID
+			msgs = append(msgs, pubsub.Message[uuid.UUID, event]{Key: le.ListID, Value: eventFromLog(le)})
+		}
+		return msgs, cursor, nil
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// seqs returns the sequence numbers of the events.
+func seqs(events []event) []int64 {
+	r := make([]int64, 0, len(events))
+	for _, ev := range events {
+		r = append(r, ev.Seq)
+	}
+	return r
+}
+
+func TestCatchUp(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, a := env.list(owner)
+
+	// a second entry and updates of both interleaved
+	rec := env.do(http.MethodPost, "/api/v1/entries", map[string]any{"Name": "bread", "ListID": l.ID}, owner)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating entry failed with %v", rec.Code)
+	}
+	var b struct{ ID string }
+	env.decode(rec, &b)
+	for _, id := range []string{a.ID.String(), b.ID, a.ID.String()} {
+		path := fmt.Sprintf("/api/v1/entries/%v", id)
+		if rec := env.do(http.MethodPatch, path, map[string]any{"Number": "2"}, owner); rec.Code != http.StatusOK {
+			t.Fatalf("updating entry failed with %v", rec.Code)
+		}
+		if rec := env.do(http.MethodPatch, path, map[string]any{"Number": ""}, owner); rec.Code != http.StatusOK {
+			t.Fatalf("updating entry failed with %v", rec.Code)
+		}
+	}
+
+	log, err := env.s.eventsAfter(l.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(seqs(log)) != "[1 2 3 4 5 6 7 8]" {
+		t.Fatalf("unexpected event log %v", seqs(log))
+	}
+	// the updates of different entries are not merged
+	if _, ok := mergeEvents(log[3], log[4]); ok {
+		t.Error("updates of different entries merged")
+	}
+	// the two updates of the second entry are
+	merged, ok := mergeEvents(log[4], log[5])
+	if !ok {
+		t.Fatal("updates of the same entry not merged")
+	}
+
+	tests := []struct {
+		name    string
+		ev      event
+		lastSeq int64
+		want    string
+	}{
+		{"next event", log[2], 2, "[3]"},
+		{"already sent", log[2], 3, "[]"},
+		{"dropped events", log[4], 2, "[3 4 5 6 7 8]"},
+		{"merged events", merged, 4, "[6]"},
+		{"dropped before merged events", merged, 3, "[4 5 6 7 8]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := env.s.catchUp(tt.ev, tt.lastSeq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(seqs(events)); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// receiveEvent returns the next event from ch or fails after two poll
+// intervals.
+func receiveEvent(t *testing.T, ch chan event) (event, bool) {
+	t.Helper()
+	select {
+	case ev, ok := <-ch:
+		return ev, ok
+	case <-time.After(4 * pubSubPollInterval):
+		t.Fatal("timeout while waiting for event")
+	}
+	return event{}, false
+}
+
+func TestPollingDelivery(t *testing.T) {
+	env := newTestEnvWithConfig(t, Config{SessionIdleTimeout: time.Hour, PubSub: PubSubDatabase})
+	owner := env.user("owner")
+	l, e := env.list(owner)
+	_, o := env.list(owner)
+
+	// a second instance sharing the database
+	s2, err := New(env.db, Config{PubSub: PubSubDatabase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s2.Shutdown)
+	before, err := env.s.eventsAfter(l.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances := map[string]server{"publishing instance": env.s, "other instance": s2}
+	subs := map[string]chan event{}
+	for name, s := range instances {
+		id, ch, err := s.eventPubSub.Subscribe(l.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.eventPubSub.Unsubscribe(l.ID, id) })
+		subs[name] = ch
+	}
+
+	for i := range 5 {
+		for _, id := range []uuid.UUID{e.ID, o.ID} {
+			path := fmt.Sprintf("/api/v1/entries/%v", id)
+			if rec := env.do(http.MethodPatch, path, map[string]any{"Number": fmt.Sprint(i + 1)}, owner); rec.Code != http.StatusOK {
+				t.Fatalf("updating entry failed with %v", rec.Code)
+			}
+		}
+	}
+
+	// the events of the list arrive in order and the ones of the other list
+	// do not. Events written shortly before subscribing may still arrive, the
+	// streams skip them by their sequence number.
+	want := before[len(before)-1].Seq + 5
+	for name, ch := range subs {
+		t.Run(name, func(t *testing.T) {
+			var last int64
+			// the second instance starts polling after the existing events
+			if name == "other instance" {
+				last = want - 5
+			}
+			for last < want {
+				ev, ok := receiveEvent(t, ch)
+				if !ok {
+					t.Fatal("channel closed")
+				}
+				if ev.ListID != l.ID || ev.Seq != last+1 && last != 0 {
+					t.Fatalf("got %v %v of %v after %v", ev.Type, ev.Seq, ev.ListID, last)
+				}
+				last = ev.Seq
+			}
+			select {
+			case ev := <-ch:
+				t.Errorf("got unexpected %v %v of %v", ev.Type, ev.Seq, ev.ListID)
+			case <-time.After(2 * pubSubPollInterval):
+			}
+		})
+	}
+}
+
+func TestPollingUnsubscribe(t *testing.T) {
+	env := newTestEnvWithConfig(t, Config{SessionIdleTimeout: time.Hour, PubSub: PubSubDatabase})
+	owner := env.user("owner")
+	l, e := env.list(owner)
+	s2, err := New(env.db, Config{PubSub: PubSubDatabase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s2.Shutdown)
+
+	id, ch, err := s2.eventPubSub.Subscribe(l.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2.eventPubSub.Unsubscribe(l.ID, id)
+	if _, ok := receiveEvent(t, ch); ok {
+		t.Error("channel not closed")
+	}
+	if n := s2.eventPubSub.Stats().Subscribers[l.ID]; n != 0 {
+		t.Errorf("got %v subscribers after unsubscribe, want 0", n)
+	}
+
+	// polling goes on without the subscriber
+	path := fmt.Sprintf("/api/v1/entries/%v", e.ID)
+	if rec := env.do(http.MethodPatch, path, map[string]any{"Number": "2"}, owner); rec.Code != http.StatusOK {
+		t.Fatalf("updating entry failed with %v", rec.Code)
+	}
+	_, ch, err = s2.eventPubSub.Subscribe(l.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := env.do(http.MethodPatch, path, map[string]any{"Number": "3"}, owner); rec.Code != http.StatusOK {
+		t.Fatalf("updating entry failed with %v", rec.Code)
+	}
+	if ev, _ := receiveEvent(t, ch); ev.Type != eventEntryUpdated {
+		t.Errorf("got %v after subscribing again, want %v", ev.Type, eventEntryUpdated)
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+	"gorm.io/gorm"
+)
+
+// invitationCreated is the response to the creation of an invitation. This
+// is the only time, the code is available.
+type invitationCreated struct {
+	database.Invitation
+	Code string
+}
+
+func (s server) invitationList() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		is := []database.Invitation{}
+		if err := s.db.Order("created_at desc").Find(&is).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get invitations from database, %w", err))
+		}
+		return c.JSON(http.StatusOK, is)
+	}
+}
+
+func (s server) invitationCreate() echo.HandlerFunc {
+	type input struct {
+		ExpiresAt *time.Time `json:"ExpiresAt"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		if i.ExpiresAt != nil && i.ExpiresAt.Before(time.Now()) {
+			return echo.ErrBadRequest.SetInternal(errors.New("ExpiresAt in the past"))
+		}
+
+		user, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		code, hash, err := newSecret()
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		inv := database.Invitation{
+			CodeHash:    hash,
+			CreatedByID: user.ID,
+			ExpiresAt:   i.ExpiresAt,
+		}
+		if err := s.db.Create(&inv).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusCreated, invitationCreated{Invitation: inv, Code: code})
+	}
+}
+
+func (s server) invitationDelete() echo.HandlerFunc {
+	type input struct {
+		ID uuid.UUID `param:"ID"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		var inv database.Invitation
+		if err := s.db.First(&inv, i.ID).Error; err != nil {
+			return echo.NotFoundHandler(c)
+		}
+		if err := s.db.Delete(&inv).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to delete invitation %v, %w", inv.ID, err))
+		}
+		return c.JSON(http.StatusOK, inv)
+	}
+}
+
+// errInvalidInvitation is returned for unknown, used and expired invitations alike.
+var errInvalidInvitation = errors.New("invalid invitation code")
+
+func (s server) register() echo.HandlerFunc {
+	type input struct {
+		Code     string `json:"Code"`
+		Name     string `json:"Name"`
+		Password string `json:"Password"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		if i.Code == "" || i.Name == "" || i.Password == "" {
+			return echo.ErrBadRequest.SetInternal(errors.New("missing code, name or password"))
+		}
+
+		// Check the invitation before hashing the password, so bogus codes do
+		// not cost any hashing.
+		var inv database.Invitation
+		if err := s.db.First(&inv, "code_hash = ?", hashSecret(i.Code)).Error; err != nil {
+			return echo.NewHTTPError(http.StatusForbidden, errInvalidInvitation.Error()).SetInternal(err)
+		}
+		if inv.UsedAt != nil {
+			return echo.NewHTTPError(http.StatusForbidden, errInvalidInvitation.Error()).SetInternal(fmt.Errorf("invitation %v already used", inv.ID))
+		}
+		if inv.ExpiresAt != nil && !time.Now().Before(*inv.ExpiresAt) {
+			return echo.NewHTTPError(http.StatusForbidden, errInvalidInvitation.Error()).SetInternal(fmt.Errorf("invitation %v expired", inv.ID))
+		}
+
+		hash, err := database.HashPassword(i.Password)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		u := database.User{
+			Name:         i.Name,
+			PasswordHash: hash,
+		}
+
+		// Use the invitation and create the user at once, so a taken name does
+		// not use up the invitation. The invitation may have been used in the
+		// meantime.
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&u).Error; err != nil {
+				return fmt.Errorf("unable to create user, %w", err)
+			}
+
+			x := tx.Model(&inv).
+				Where("used_at IS NULL").
+				Updates(map[string]any{"used_by_id": u.ID, "used_at": time.Now()})
+			if x.Error != nil {
+				return fmt.Errorf("unable to use invitation, %w", x.Error)
+			}
+			if x.RowsAffected == 0 {
+				return fmt.Errorf("%w, already used", errInvalidInvitation)
+			}
+			return nil
+		})
+		if errors.Is(err, errInvalidInvitation) {
+			return echo.NewHTTPError(http.StatusForbidden, errInvalidInvitation.Error()).SetInternal(err)
+		}
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errUserNameTaken(err)
+		}
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusCreated, u)
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shaardie/listinator/database"
+)
+
+func TestRegister(t *testing.T) {
+	env := newTestEnv(t)
+	env.user("admin")
+	var admin database.User
+	if err := env.db.First(&admin, "name = ?", "admin").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	code, hash, err := newSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.db.Create(&database.Invitation{CodeHash: hash, CreatedByID: admin.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	expiredCode, hash, err := newSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := env.db.Create(&database.Invitation{CodeHash: hash, CreatedByID: admin.ID, ExpiresAt: &past}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	register := func(code, name string) int {
+		return env.do(http.MethodPost, "/api/v1/register", map[string]string{"Code": code, "Name": name, "Password": "secret"}, nil).Code
+	}
+	if got := register("bogus", "mallory"); got != http.StatusForbidden {
+		t.Errorf("bogus code got %v, want 403", got)
+	}
+	if got := register(expiredCode, "mallory"); got != http.StatusForbidden {
+		t.Errorf("expired code got %v, want 403", got)
+	}
+	if got := register(code, "alice"); got != http.StatusCreated {
+		t.Errorf("valid code got %v, want 201", got)
+	}
+	if got := register(code, "bob"); got != http.StatusForbidden {
+		t.Errorf("used code got %v, want 403", got)
+	}
+}
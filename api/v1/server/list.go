@@ -1,19 +1,177 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/shaardie/listinator/database"
+	"gorm.io/gorm"
 )
 
+func (s server) listList() echo.HandlerFunc {
+	type input struct {
+		Archived bool `query:"Archived"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		user, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		if user == nil {
+			return echo.ErrUnauthorized
+		}
+
+		ls := []database.List{}
+		if err := s.db.
+			Joins("JOIN list_members ON list_members.list_id = lists.id AND list_members.deleted_at IS NULL").
+			Where("list_members.user_id = ? AND lists.archived = ?", user.ID, i.Archived).
+			Order("lists.name asc").
+			Find(&ls).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get lists from database, %w", err))
+		}
+		return c.JSON(http.StatusOK, ls)
+	}
+}
+
 func (s server) listCreate() echo.HandlerFunc {
+	type input struct {
+		Name        string `json:"Name"`
+		Description string `json:"Description"`
+	}
 	return func(c echo.Context) error {
-		l := database.List{}
-		if err := s.db.Create(&l).Error; err != nil {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+		if strings.TrimSpace(i.Name) == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "missing Name")
+		}
+
+		user, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		l := database.List{
+			Name:        i.Name,
+			Description: i.Description,
+			OwnerID:     &user.ID,
+		}
+
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&l).Error; err != nil {
+				return fmt.Errorf("unable to create list, %w", err)
+			}
+			m := database.ListMember{ListID: l.ID, UserID: user.ID, Role: database.RoleOwner}
+			if err := tx.Create(&m).Error; err != nil {
+				return fmt.Errorf("unable to create owner membership, %w", err)
+			}
+			return nil
+		})
+		if err != nil {
 			return echo.ErrInternalServerError.SetInternal(err)
 		}
 
 		return c.JSON(http.StatusCreated, l)
 	}
 }
+
+func (s server) listGet() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusOK, a.List)
+	}
+}
+
+func (s server) listUpdate() echo.HandlerFunc {
+	type input struct {
+		Name        string `json:"Name"`
+		Description string `json:"Description"`
+		Archived    bool   `json:"Archived"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+		if strings.TrimSpace(i.Name) == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "missing Name")
+		}
+
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		l := a.List
+		renamed := l.Name != i.Name
+		l.Name = i.Name
+		l.Description = i.Description
+		l.Archived = i.Archived
+
+		_, err = s.transact(func(tx *gorm.DB) ([]event, error) {
+			if err := tx.Save(&l).Error; err != nil {
+				return nil, err
+			}
+			if !renamed {
+				return nil, nil
+			}
+			ev, err := newEvent(eventListRenamed, l.ID, actorID(c), listPayload{ID: l.ID, Name: l.Name})
+			if err != nil {
+				return nil, err
+			}
+			return []event{ev}, nil
+		})
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusOK, l)
+	}
+}
+
+func (s server) listDelete() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		l := a.List
+
+		// Delete the entries, memberships and operations together with the
+		// list, so they do not linger around. The revisions are kept like the
+		// soft deleted list, so the history survives restoring it. The event
+		// log is kept, so instances polling it still deliver the list.deleted
+		// event.
+		_, err = s.transact(func(tx *gorm.DB) ([]event, error) {
+			if err := tx.Where("list_id = ?", l.ID).Delete(&database.Entry{}).Error; err != nil {
+				return nil, fmt.Errorf("unable to delete entries, %w", err)
+			}
+			if err := tx.Unscoped().Where("list_id = ?", l.ID).Delete(&database.ListMember{}).Error; err != nil {
+				return nil, fmt.Errorf("unable to delete members, %w", err)
+			}
+			if err := tx.Unscoped().Where("list_id = ?", l.ID).Delete(&database.ListOperation{}).Error; err != nil {
+				return nil, fmt.Errorf("unable to delete operations, %w", err)
+			}
+			if err := tx.Delete(&l).Error; err != nil {
+				return nil, fmt.Errorf("unable to delete list, %w", err)
+			}
+			ev, err := newEvent(eventListDeleted, l.ID, actorID(c), listPayload{ID: l.ID, Name: l.Name})
+			if err != nil {
+				return nil, err
+			}
+			return []event{ev}, nil
+		})
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to delete list %v, %w", l.ID, err))
+		}
+		return c.JSON(http.StatusOK, l)
+	}
+}
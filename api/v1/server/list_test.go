@@ -0,0 +1,36 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestListName(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, _ := env.list(owner)
+	path := fmt.Sprintf("/api/v1/lists/%v", l.ID)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   map[string]any
+		want   int
+	}{
+		{"create without name", http.MethodPost, "/api/v1/lists", map[string]any{"Description": "weekly"}, http.StatusBadRequest},
+		{"create with blank name", http.MethodPost, "/api/v1/lists", map[string]any{"Name": " \t"}, http.StatusBadRequest},
+		{"update without name", http.MethodPut, path, map[string]any{"Name": ""}, http.StatusBadRequest},
+		{"update with blank name", http.MethodPut, path, map[string]any{"Name": "  "}, http.StatusBadRequest},
+		{"update with name", http.MethodPut, path, map[string]any{"Name": "hardware"}, http.StatusOK},
+		{"create with name", http.MethodPost, "/api/v1/lists", map[string]any{"Name": "pharmacy"}, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := env.do(tt.method, tt.path, tt.body, owner); rec.Code != tt.want {
+				t.Errorf("got %v, want %v", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+	"gorm.io/gorm"
+)
+
+// member is the representation of a list member in the API
+type member struct {
+	UserID uuid.UUID
+	Name   string
+	Role   database.Role
+}
+
+func newMember(m database.ListMember) member {
+	return member{
+		UserID: m.UserID,
+		Name:   m.User.Name,
+		Role:   m.Role,
+	}
+}
+
+func (s server) memberList() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		ms := []database.ListMember{}
+		if err := s.db.Preload("User").Where("list_id = ?", a.List.ID).Order("created_at asc").Find(&ms).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get members from database, %w", err))
+		}
+
+		res := make([]member, 0, len(ms))
+		for _, m := range ms {
+			res = append(res, newMember(m))
+		}
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+func (s server) memberCreate() echo.HandlerFunc {
+	type input struct {
+		Name string        `json:"Name"`
+		Role database.Role `json:"Role"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		// There is only one owner per list
+		if i.Role != database.RoleEditor && i.Role != database.RoleViewer {
+			return echo.ErrBadRequest.SetInternal(fmt.Errorf("invalid role %q", i.Role))
+		}
+
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		var u database.User
+		if err := s.db.First(&u, "name = ?", i.Name).Error; err != nil {
+			return echo.ErrBadRequest.SetInternal(fmt.Errorf("unable to get user %v, %w", i.Name, err))
+		}
+
+		var count int64
+		if err := s.db.Model(&database.ListMember{}).Where("list_id = ? AND user_id = ?", a.List.ID, u.ID).Count(&count).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to check membership, %w", err))
+		}
+		if count > 0 {
+			return echo.NewHTTPError(http.StatusConflict, "user is already a member")
+		}
+
+		m := database.ListMember{
+			ListID: a.List.ID,
+			UserID: u.ID,
+			User:   u,
+			Role:   i.Role,
+		}
+		_, err = s.transact(func(tx *gorm.DB) ([]event, error) {
+			if err := tx.Omit("User").Create(&m).Error; err != nil {
+				return nil, err
+			}
+			ev, err := newEvent(eventMemberAdded, m.ListID, actorID(c), newMember(m))
+			if err != nil {
+				return nil, err
+			}
+			return []event{ev}, nil
+		})
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusCreated, newMember(m))
+	}
+}
+
+func (s server) memberUpdate() echo.HandlerFunc {
+	type input struct {
+		UserID uuid.UUID     `param:"userID"`
+		Role   database.Role `json:"Role"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		if i.Role != database.RoleEditor && i.Role != database.RoleViewer {
+			return echo.ErrBadRequest.SetInternal(fmt.Errorf("invalid role %q", i.Role))
+		}
+
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		var m database.ListMember
+		if err := s.db.Preload("User").Where("list_id = ? AND user_id = ?", a.List.ID, i.UserID).First(&m).Error; err != nil {
+			return echo.NotFoundHandler(c)
+		}
+		if m.Role == database.RoleOwner {
+			return echo.ErrBadRequest.SetInternal(errors.New("unable to change role of the owner"))
+		}
+
+		m.Role = i.Role
+		if err := s.db.Omit("User").Save(&m).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusOK, newMember(m))
+	}
+}
+
+func (s server) memberDelete() echo.HandlerFunc {
+	type input struct {
+		UserID uuid.UUID `param:"userID"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		user, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		// Owners can remove everybody, everybody else can only leave
+		if a.Role != database.RoleOwner && (user == nil || user.ID != i.UserID) {
+			return echo.ErrForbidden
+		}
+
+		var m database.ListMember
+		err = s.db.Preload("User").Where("list_id = ? AND user_id = ?", a.List.ID, i.UserID).First(&m).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return echo.NotFoundHandler(c)
+		}
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		if m.Role == database.RoleOwner {
+			return echo.ErrBadRequest.SetInternal(errors.New("unable to remove the owner"))
+		}
+
+		// Delete for real, so the user can be added again later
+		if err := s.db.Unscoped().Delete(&m).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to delete member, %w", err))
+		}
+		return c.JSON(http.StatusOK, newMember(m))
+	}
+}
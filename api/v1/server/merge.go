@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+	"github.com/shaardie/listinator/quantity"
+	"gorm.io/gorm"
+)
+
+// duplicateKey identifies the entries of a list, which can be merged.
+type duplicateKey struct {
+	name   string
+	bought bool
+	unit   quantity.Unit
+}
+
+// entryQuantity returns the quantity of the entry e. An entry without number
+// is a single piece.
+func entryQuantity(e database.Entry) (quantity.Quantity, bool) {
+	if strings.TrimSpace(e.Number) == "" {
+		return quantity.Quantity{Amount: 1, Unit: quantity.Piece}, true
+	}
+	if e.Amount == nil {
+		return quantity.Quantity{}, false
+	}
+	return quantity.Quantity{Amount: *e.Amount, Unit: e.Unit}, true
+}
+
+// listMergeDuplicates merges the entries of the list with the same name and
+// compatible quantities into the oldest of them, e.g. "milk 1 l" and
+// "milk 500 ml" into "milk 1.5 l". Entries, whose number is not a quantity,
+// are left alone. The merged duplicates are deleted and can be restored from
+// the trash. The subscribers get a single entry.batch event.
+func (s server) listMergeDuplicates() echo.HandlerFunc {
+	type output struct {
+		// Merged are the entries the duplicates were merged into.
+		Merged []database.Entry
+		// Deleted are the IDs of the merged duplicates.
+		Deleted []uuid.UUID
+	}
+	return func(c echo.Context) error {
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		o := output{
+			Merged:  []database.Entry{},
+			Deleted: []uuid.UUID{},
+		}
+		_, err = s.transact(func(tx *gorm.DB) ([]event, error) {
+			es := []database.Entry{}
+			if err := tx.Where("list_id = ?", a.List.ID).Order("created_at, id").Find(&es).Error; err != nil {
+				return nil, fmt.Errorf("unable to get entries from database, %w", err)
+			}
+
+			keys := []duplicateKey{}
+			groups := map[duplicateKey][]database.Entry{}
+			for _, e := range es {
+				q, ok := entryQuantity(e)
+				if !ok {
+					continue
+				}
+				k := duplicateKey{
+					name:   strings.ToLower(strings.TrimSpace(e.Name)),
+					bought: e.Bought,
+					unit:   q.Unit,
+				}
+				if _, ok := groups[k]; !ok {
+					keys = append(keys, k)
+				}
+				groups[k] = append(groups[k], e)
+			}
+
+			events := []event{}
+			for _, k := range keys {
+				g := groups[k]
+				if len(g) < 2 {
+					continue
+				}
+
+				sum, _ := entryQuantity(g[0])
+				for _, e := range g[1:] {
+					q, _ := entryQuantity(e)
+					var err error
+					if sum, err = sum.Add(q); err != nil {
+						return nil, err
+					}
+					evs, err := removeEntry(c, tx, e)
+					if err != nil {
+						return nil, err
+					}
+					o.Deleted = append(o.Deleted, e.ID)
+					events = append(events, evs...)
+				}
+
+				merged := g[0]
+				merged.SetNumber(sum.String())
+				merged.Version++
+				evs, err := saveEntry(c, tx, g[0], merged)
+				if err != nil {
+					return nil, err
+				}
+				o.Merged = append(o.Merged, merged)
+				events = append(events, evs...)
+			}
+			return newBatchEvents(c, events)
+		})
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusOK, o)
+	}
+}
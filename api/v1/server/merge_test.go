@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/shaardie/listinator/database"
+)
+
+func TestListMergeDuplicates(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, milk := env.list(owner)
+	ops := []map[string]any{
+		{"Op": "update", "ID": milk.ID, "Entry": map[string]any{"Name": "milk", "Number": "1 l"}},
+		{"Op": "create", "Entry": map[string]any{"Name": " Milk", "Number": "500 ml"}},
+		{"Op": "create", "Entry": map[string]any{"Name": "milk", "Number": "1 l", "Bought": true}},
+		{"Op": "create", "Entry": map[string]any{"Name": "milk", "Number": "2"}},
+		{"Op": "create", "Entry": map[string]any{"Name": "eggs"}},
+		{"Op": "create", "Entry": map[string]any{"Name": "eggs", "Number": "6"}},
+		{"Op": "create", "Entry": map[string]any{"Name": "cheese", "Number": "a slice"}},
+		{"Op": "create", "Entry": map[string]any{"Name": "cheese", "Number": "a slice"}},
+		{"Op": "create", "Entry": map[string]any{"Name": "saffron", "Number": "0.1 mg"}},
+		{"Op": "create", "Entry": map[string]any{"Name": "saffron", "Number": "0.2 mg"}},
+	}
+	if got := env.batch(l, ops, owner); got != http.StatusOK {
+		t.Fatalf("creating entries failed with %v", got)
+	}
+	path := fmt.Sprintf("/api/v1/lists/%v/merge-duplicates", l.ID)
+	viewer := env.user("viewer")
+	rec := env.do(http.MethodPost, fmt.Sprintf("/api/v1/lists/%v/members", l.ID), map[string]any{"Name": "viewer", "Role": database.RoleViewer}, owner)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("adding member failed with %v", rec.Code)
+	}
+	if rec := env.do(http.MethodPost, path, nil, viewer); rec.Code != http.StatusForbidden {
+		t.Errorf("merge by viewer got %v, want 403", rec.Code)
+	}
+
+	id, ch, err := env.s.eventPubSub.Subscribe(l.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.s.eventPubSub.Unsubscribe(l.ID, id)
+
+	rec = env.do(http.MethodPost, path, nil, owner)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("merge got %v, want 200", rec.Code)
+	}
+	var o struct {
+		Merged  []database.Entry
+		Deleted []string
+	}
+	env.decode(rec, &o)
+	if len(o.Merged) != 3 || len(o.Deleted) != 3 {
+		t.Errorf("merged into %v entries and deleted %v, want 3 and 3", len(o.Merged), len(o.Deleted))
+	}
+
+	// milk by volume, milk as pieces and the bought milk stay apart, the
+	// cheese is no quantity
+	var es []database.Entry
+	if err := env.db.Where("list_id = ?", l.ID).Find(&es).Error; err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0, len(es))
+	for _, e := range es {
+		got = append(got, fmt.Sprintf("%v %v %v", e.Name, e.Number, e.Bought))
+	}
+	slices.Sort(got)
+	want := []string{
+		"cheese a slice false",
+		"cheese a slice false",
+		"eggs 7 false",
+		"milk 1 l true",
+		"milk 1.5 l false",
+		"milk 2 false",
+		"saffron 0.3 mg false",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v after merge, want %v", got, want)
+	}
+	if trash := env.trash(l, owner); len(trash) != 3 {
+		t.Errorf("got trash %v, want the 3 merged duplicates", trash)
+	}
+
+	// the subscribers get a single event
+	select {
+	case ev := <-ch:
+		if ev.Type != eventEntryBatch {
+			t.Fatalf("got event %v, want %v", ev.Type, eventEntryBatch)
+		}
+		var payload batchPayload
+		if err := json.Unmarshal(ev.Payload, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if len(payload.Events) != 6 {
+			t.Errorf("got %v events in batch, want 3 updates and 3 deletions", len(payload.Events))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+	select {
+	case ev := <-ch:
+		t.Errorf("got another event %v", ev.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// nothing left to merge
+	rec = env.do(http.MethodPost, path, nil, owner)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("second merge got %v, want 200", rec.Code)
+	}
+	env.decode(rec, &o)
+	if len(o.Merged) != 0 || len(o.Deleted) != 0 {
+		t.Errorf("second merge merged %v and deleted %v entries, want none", len(o.Merged), len(o.Deleted))
+	}
+}
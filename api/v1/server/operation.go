@@ -0,0 +1,155 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+	"gorm.io/gorm"
+)
+
+// errOperationUndone is returned, if an operation is undone twice.
+var errOperationUndone = errors.New("operation already undone")
+
+// listClearBought deletes all bought entries of the list.
+func (s server) listClearBought() echo.HandlerFunc {
+	return s.listOperation(database.OperationClearBought)
+}
+
+// listUncheckAll marks all bought entries of the list as not bought.
+func (s server) listUncheckAll() echo.HandlerFunc {
+	return s.listOperation(database.OperationUncheckAll)
+}
+
+// listOperation changes all bought entries of the list at once according to
+// the kind of the operation. The affected entries are recorded, so the
+// operation can be undone. The subscribers get a single entry.batch event.
+func (s server) listOperation(kind string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		op := database.ListOperation{
+			ListID:   a.List.ID,
+			UserID:   actorID(c),
+			Kind:     kind,
+			EntryIDs: []uuid.UUID{},
+		}
+		_, err = s.transact(func(tx *gorm.DB) ([]event, error) {
+			es := []database.Entry{}
+			if err := tx.Where("list_id = ? AND bought = ?", a.List.ID, true).Find(&es).Error; err != nil {
+				return nil, fmt.Errorf("unable to get entries from database, %w", err)
+			}
+
+			events := []event{}
+			for _, e := range es {
+				var evs []event
+				var err error
+				switch kind {
+				case database.OperationClearBought:
+					evs, err = removeEntry(c, tx, e)
+				case database.OperationUncheckAll:
+					changed := e
+					changed.Bought = false
+					changed.Version++
+					evs, err = saveEntry(c, tx, e, changed)
+				}
+				if err != nil {
+					return nil, err
+				}
+				op.EntryIDs = append(op.EntryIDs, e.ID)
+				events = append(events, evs...)
+			}
+
+			if err := tx.Create(&op).Error; err != nil {
+				return nil, fmt.Errorf("unable to record operation, %w", err)
+			}
+			return newBatchEvents(c, events)
+		})
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusCreated, op)
+	}
+}
+
+// listOperationUndo reverts an operation for the affected entries, which are
+// still in the list.
+func (s server) listOperationUndo() echo.HandlerFunc {
+	type input struct {
+		OperationID uuid.UUID `param:"operationID"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		var op database.ListOperation
+		if err := s.db.Where("list_id = ?", a.List.ID).First(&op, i.OperationID).Error; err != nil {
+			return echo.NotFoundHandler(c)
+		}
+
+		_, err = s.transact(func(tx *gorm.DB) ([]event, error) {
+			now := time.Now()
+			res := tx.Model(&op).Where("undone_at IS NULL").Update("undone_at", now)
+			if res.Error != nil {
+				return nil, fmt.Errorf("unable to update operation, %w", res.Error)
+			}
+			if res.RowsAffected == 0 {
+				return nil, errOperationUndone
+			}
+
+			events := []event{}
+			switch op.Kind {
+			case database.OperationClearBought:
+				es := []database.Entry{}
+				if err := tx.Unscoped().Where("id IN ? AND list_id = ? AND deleted_at IS NOT NULL", op.EntryIDs, op.ListID).Find(&es).Error; err != nil {
+					return nil, fmt.Errorf("unable to get entries from database, %w", err)
+				}
+				for _, e := range es {
+					_, evs, err := restoreEntry(c, tx, e)
+					if err != nil {
+						return nil, err
+					}
+					events = append(events, evs...)
+				}
+			case database.OperationUncheckAll:
+				es := []database.Entry{}
+				if err := tx.Where("id IN ? AND list_id = ? AND bought = ?", op.EntryIDs, op.ListID, false).Find(&es).Error; err != nil {
+					return nil, fmt.Errorf("unable to get entries from database, %w", err)
+				}
+				for _, e := range es {
+					changed := e
+					changed.Bought = true
+					changed.Version++
+					evs, err := saveEntry(c, tx, e, changed)
+					if err != nil {
+						return nil, err
+					}
+					events = append(events, evs...)
+				}
+			}
+			op.UndoneAt = &now
+			return newBatchEvents(c, events)
+		})
+		if errors.Is(err, errOperationUndone) {
+			return echo.NewHTTPError(http.StatusConflict, errOperationUndone.Error())
+		}
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusOK, op)
+	}
+}
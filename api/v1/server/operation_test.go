@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/shaardie/listinator/database"
+)
+
+// listState returns the fields clients can change of all entries of the
+// list in a comparable form.
+func (env *testEnv) listState(l database.List) []string {
+	env.t.Helper()
+	var es []database.Entry
+	if err := env.db.Where("list_id = ?", l.ID).Find(&es).Error; err != nil {
+		env.t.Fatal(err)
+	}
+	state := make([]string, 0, len(es))
+	for _, e := range es {
+		state = append(state, fmt.Sprintf("%v %v %q %v %v", e.ID, e.Name, e.Number, e.Bought, e.TypeID))
+	}
+	slices.Sort(state)
+	return state
+}
+
+// operationList creates a list with milk and bread, which are bought, and
+// eggs, which are not.
+func (env *testEnv) operationList(cookie *http.Cookie) database.List {
+	env.t.Helper()
+	l, milk := env.list(cookie)
+	ops := []map[string]any{
+		{"Op": "update", "ID": milk.ID, "Entry": map[string]any{"Name": "milk", "Number": "2 l", "Bought": true}},
+		{"Op": "create", "Entry": map[string]any{"Name": "bread", "Bought": true}},
+		{"Op": "create", "Entry": map[string]any{"Name": "eggs", "Number": "6"}},
+	}
+	if got := env.batch(l, ops, cookie); got != http.StatusOK {
+		env.t.Fatalf("creating entries failed with %v", got)
+	}
+	return l
+}
+
+func TestListOperationUndo(t *testing.T) {
+	tests := []struct {
+		op   string
+		want []string
+	}{
+		{"clear-bought", []string{"eggs"}},
+		{"uncheck-all", []string{"bread", "eggs", "milk"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.op, func(t *testing.T) {
+			env := newTestEnv(t)
+			owner := env.user("owner")
+			l := env.operationList(owner)
+			before := env.listState(l)
+
+			rec := env.do(http.MethodPost, fmt.Sprintf("/api/v1/lists/%v/%v", l.ID, tt.op), nil, owner)
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("operation got %v, want 201", rec.Code)
+			}
+			var op database.ListOperation
+			env.decode(rec, &op)
+			if len(op.EntryIDs) != 2 {
+				t.Errorf("operation affected %v entries, want 2", len(op.EntryIDs))
+			}
+			names := env.entryNames(l)
+			if got := slices.Sorted(maps.Keys(names)); !slices.Equal(got, tt.want) {
+				t.Errorf("list contains %v, want %v", got, tt.want)
+			}
+			for name, bought := range names {
+				if bought {
+					t.Errorf("%v still bought", name)
+				}
+			}
+
+			// undo restores the exact previous state
+			undo := fmt.Sprintf("/api/v1/lists/%v/operations/%v/undo", l.ID, op.ID)
+			if rec := env.do(http.MethodPost, undo, nil, owner); rec.Code != http.StatusOK {
+				t.Fatalf("undo got %v, want 200", rec.Code)
+			}
+			if after := env.listState(l); !slices.Equal(after, before) {
+				t.Errorf("got %v after undo, want %v", after, before)
+			}
+
+			// but only once
+			if rec := env.do(http.MethodPost, undo, nil, owner); rec.Code != http.StatusConflict {
+				t.Fatalf("second undo got %v, want 409", rec.Code)
+			}
+			if after := env.listState(l); !slices.Equal(after, before) {
+				t.Errorf("got %v after second undo, want %v", after, before)
+			}
+		})
+	}
+}
+
+func TestListOperationUndoChanged(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l := env.operationList(owner)
+	rec := env.do(http.MethodPost, fmt.Sprintf("/api/v1/lists/%v/uncheck-all", l.ID), nil, owner)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("operation got %v, want 201", rec.Code)
+	}
+	var op database.ListOperation
+	env.decode(rec, &op)
+
+	// entries changed or deleted since are left alone
+	var milk, bread database.Entry
+	if err := env.db.Where("list_id = ? AND name = ?", l.ID, "milk").First(&milk).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := env.db.Where("list_id = ? AND name = ?", l.ID, "bread").First(&bread).Error; err != nil {
+		t.Fatal(err)
+	}
+	if rec := env.do(http.MethodDelete, fmt.Sprintf("/api/v1/entries/%v", milk.ID), nil, owner); rec.Code != http.StatusOK {
+		t.Fatalf("deleting milk failed with %v", rec.Code)
+	}
+	if rec := env.patch(bread, `{"Bought":true}`, owner); rec.Code != http.StatusOK {
+		t.Fatalf("buying bread failed with %v", rec.Code)
+	}
+	before := env.listState(l)
+
+	if rec := env.do(http.MethodPost, fmt.Sprintf("/api/v1/lists/%v/operations/%v/undo", l.ID, op.ID), nil, owner); rec.Code != http.StatusOK {
+		t.Fatalf("undo got %v, want 200", rec.Code)
+	}
+	if after := env.listState(l); !slices.Equal(after, before) {
+		t.Errorf("got %v after undo, want %v", after, before)
+	}
+}
+
+func TestListOperationUndoOtherList(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l := env.operationList(owner)
+	other, _ := env.list(owner)
+	rec := env.do(http.MethodPost, fmt.Sprintf("/api/v1/lists/%v/clear-bought", l.ID), nil, owner)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("operation got %v, want 201", rec.Code)
+	}
+	var op database.ListOperation
+	env.decode(rec, &op)
+
+	if rec := env.do(http.MethodPost, fmt.Sprintf("/api/v1/lists/%v/operations/%v/undo", other.ID, op.ID), nil, owner); rec.Code != http.StatusNotFound {
+		t.Errorf("undo in other list got %v, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// presenceEvent informs the watchers of a list about other watchers joining
+// or leaving. Presence is tracked per instance and not written to the event
+// log, so these events have no sequence number.
+type presenceEvent struct {
+	// Type is presence.joined or presence.left.
+	Type    eventType
+	Watcher watcher
+	Time    time.Time
+}
+
+// envelope converts the presence event of the list to an event envelope.
+func (pe presenceEvent) envelope(listID uuid.UUID) (event, error) {
+	ev, err := newEvent(pe.Type, listID, pe.Watcher.UserID, pe.Watcher)
+	ev.Time = pe.Time
+	return ev, err
+}
+
+// othersWatching returns the watchers of the list except the subscription
+// self.
+func (s server) othersWatching(a *access, self watcher) []watcher {
+	ws := s.watchers.get(a.List.ID)
+	others := make([]watcher, 0, len(ws))
+	for _, w := range ws {
+		if w.ID != self.ID {
+			others = append(others, w)
+		}
+	}
+	return others
+}
+
+func (s server) listPresence() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusOK, s.watchers.get(a.List.ID))
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/shaardie/listinator/database"
+)
+
+// watcherNames returns the names of the watchers of the list.
+func (env *testEnv) watcherNames(l database.List, cookie *http.Cookie) []string {
+	env.t.Helper()
+	rec := env.do(http.MethodGet, fmt.Sprintf("/api/v1/lists/%v/presence", l.ID), nil, cookie)
+	if rec.Code != http.StatusOK {
+		env.t.Fatalf("getting presence failed with %v", rec.Code)
+	}
+	var ws []watcher
+	env.decode(rec, &ws)
+	names := make([]string, 0, len(ws))
+	for _, w := range ws {
+		names = append(names, w.UserName)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// presence returns the watchers in the payload of the presence event.
+func presence(t *testing.T, ev sseEvent) []watcher {
+	t.Helper()
+	var envelope event
+	if err := json.Unmarshal([]byte(ev.Data), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Type == eventPresenceSnapshot {
+		var ws []watcher
+		if err := json.Unmarshal(envelope.Payload, &ws); err != nil {
+			t.Fatal(err)
+		}
+		return ws
+	}
+	var w watcher
+	if err := json.Unmarshal(envelope.Payload, &w); err != nil {
+		t.Fatal(err)
+	}
+	return []watcher{w}
+}
+
+func TestPresence(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	editor := env.user("editor")
+	l, _ := env.list(owner)
+	rec := env.do(http.MethodPost, fmt.Sprintf("/api/v1/lists/%v/members", l.ID), map[string]any{"Name": "editor", "Role": database.RoleEditor}, owner)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("adding member failed with %v", rec.Code)
+	}
+	srv := httptest.NewServer(env.e)
+	// closed after the streams
+	t.Cleanup(srv.Close)
+
+	if got := env.watcherNames(l, owner); len(got) != 0 {
+		t.Errorf("got watchers %v before anybody connected", got)
+	}
+
+	// owner is alone
+	ownerEvents, _ := openStream(t, srv, l.ID, owner, nil)
+	ev := nextEvent(t, ownerEvents)
+	if ev.Event != string(eventPresenceSnapshot) || len(presence(t, ev)) != 0 {
+		t.Fatalf("got %v %v, want empty presence.snapshot", ev.Event, ev.Data)
+	}
+
+	// editor joins and sees owner
+	editorEvents, closeEditor := openStream(t, srv, l.ID, editor, nil)
+	ev = nextEvent(t, editorEvents)
+	if ws := presence(t, ev); ev.Event != string(eventPresenceSnapshot) || len(ws) != 1 || ws[0].UserName != "owner" {
+		t.Fatalf("got %v %v, want presence.snapshot with owner", ev.Event, ev.Data)
+	}
+	ev = nextEvent(t, ownerEvents)
+	ws := presence(t, ev)
+	if ev.Event != string(eventPresenceJoined) || ws[0].UserName != "editor" || ws[0].Role != database.RoleEditor || ws[0].Transport != transportSSE {
+		t.Fatalf("got %v %v, want presence.joined of editor", ev.Event, ev.Data)
+	}
+	joined := ws[0]
+	// nobody is told about themself
+	noEvent(t, editorEvents)
+
+	if got := env.watcherNames(l, owner); !slices.Equal(got, []string{"editor", "owner"}) {
+		t.Errorf("got watchers %v, want editor and owner", got)
+	}
+
+	// editor disconnects
+	closeEditor()
+	ev = nextEvent(t, ownerEvents)
+	if ws := presence(t, ev); ev.Event != string(eventPresenceLeft) || ws[0].ID != joined.ID {
+		t.Fatalf("got %v %v, want presence.left of editor", ev.Event, ev.Data)
+	}
+	if got := env.watcherNames(l, owner); !slices.Equal(got, []string{"owner"}) {
+		t.Errorf("got watchers %v after editor left, want owner", got)
+	}
+
+	// the presence of a list is not shown to others
+	other := env.user("other")
+	if rec := env.do(http.MethodGet, fmt.Sprintf("/api/v1/lists/%v/presence", l.ID), nil, other); rec.Code != http.StatusForbidden {
+		t.Errorf("presence of foreign list got %v, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPExtractor(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted string
+		remote  string
+		want    string
+	}{
+		{"no trusted proxies", "", "10.0.0.2:1234", "10.0.0.2"},
+		{"private network not trusted", "172.28.0.0/16", "10.0.0.2:1234", "10.0.0.2"},
+		{"trusted network", "172.28.0.0/16", "172.28.0.5:1234", "198.51.100.7"},
+		{"trusted address", "192.0.2.1, 172.28.0.5", "172.28.0.5:1234", "198.51.100.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extract, err := IPExtractor(tt.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/session", nil)
+			req.RemoteAddr = tt.remote
+			req.Header.Set("X-Forwarded-For", "198.51.100.7")
+			if got := extract(req); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := IPExtractor("proxy"); err == nil {
+		t.Error("invalid proxy accepted")
+	}
+}
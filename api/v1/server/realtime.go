@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+)
+
+const (
+	transportSSE       = "sse"
+	transportWebSocket = "websocket"
+)
+
+// watcher is a client receiving the events of a list.
+type watcher struct {
+	// ID is the ID of the subscription.
+	ID uuid.UUID
+	// UserID and UserName are empty for anonymous clients.
+	UserID   *uuid.UUID
+	UserName string
+	// ShareID is set for anonymous clients with access through a share.
+	ShareID   *uuid.UUID
+	Role      database.Role
+	Transport string
+	Since     time.Time
+}
+
+// watchers tracks the clients receiving the events of the lists.
+type watchers struct {
+	m     sync.Mutex
+	lists map[uuid.UUID]map[uuid.UUID]watcher
+}
+
+func newWatchers() *watchers {
+	return &watchers{
+		lists: map[uuid.UUID]map[uuid.UUID]watcher{},
+	}
+}
+
+// add adds the watcher of the subscription id to the list.
+func (ws *watchers) add(listID, id uuid.UUID, w watcher) {
+	ws.m.Lock()
+	defer ws.m.Unlock()
+
+	l, ok := ws.lists[listID]
+	if !ok {
+		l = map[uuid.UUID]watcher{}
+		ws.lists[listID] = l
+	}
+	l[id] = w
+}
+
+// remove removes the watcher of the subscription id from the list.
+func (ws *watchers) remove(listID, id uuid.UUID) {
+	ws.m.Lock()
+	defer ws.m.Unlock()
+
+	l, ok := ws.lists[listID]
+	if !ok {
+		return
+	}
+	delete(l, id)
+	if len(l) == 0 {
+		delete(ws.lists, listID)
+	}
+}
+
+// get returns the watchers of the list ordered by the time they started.
+func (ws *watchers) get(listID uuid.UUID) []watcher {
+	ws.m.Lock()
+	defer ws.m.Unlock()
+
+	l := ws.lists[listID]
+	r := make([]watcher, 0, len(l))
+	for _, w := range l {
+		r = append(r, w)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Since.Before(r[j].Since) })
+	return r
+}
+
+// watch registers the client of the request as watcher of the list of the
+// access a until the returned function is called. The other watchers are
+// informed with presence events.
+func (s server) watch(c echo.Context, a *access, subID uuid.UUID, transport string) (watcher, func(), error) {
+	user, err := userFromContext(c)
+	if err != nil {
+		return watcher{}, nil, err
+	}
+	w := watcher{
+		ID:        subID,
+		Role:      a.Role,
+		Transport: transport,
+		Since:     time.Now(),
+	}
+	if user != nil {
+		w.UserID = &user.ID
+		w.UserName = user.Name
+	} else if a.Share != nil {
+		w.ShareID = &a.Share.ID
+	}
+
+	listID := a.List.ID
+	s.watchers.add(listID, subID, w)
+	s.presencePubSub.Publish(listID, presenceEvent{Type: eventPresenceJoined, Watcher: w, Time: time.Now()})
+	return w, func() {
+		s.watchers.remove(listID, subID)
+		s.presencePubSub.Publish(listID, presenceEvent{Type: eventPresenceLeft, Watcher: w, Time: time.Now()})
+	}, nil
+}
+
+// adminRealtime reports the counters of the pubsub and the clients watching
+// each list of this instance.
+func (s server) adminRealtime() echo.HandlerFunc {
+	type list struct {
+		ID          uuid.UUID
+		Name        string
+		Subscribers int
+		Watchers    []watcher
+	}
+	type output struct {
+		Published    uint64
+		Delivered    uint64
+		Dropped      uint64
+		Disconnected uint64
+		Lists        []list
+	}
+	return func(c echo.Context) error {
+		stats := s.eventPubSub.Stats()
+
+		ids := make([]uuid.UUID, 0, len(stats.Subscribers))
+		for id := range stats.Subscribers {
+			ids = append(ids, id)
+		}
+		names := map[uuid.UUID]string{}
+		if len(ids) > 0 {
+			ls := []database.List{}
+			if err := s.db.Select("id", "name").Find(&ls, ids).Error; err != nil {
+				return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get lists from database, %w", err))
+			}
+			for _, l := range ls {
+				names[l.ID] = l.Name
+			}
+		}
+
+		o := output{
+			Published:    stats.Published,
+			Delivered:    stats.Delivered,
+			Dropped:      stats.Dropped,
+			Disconnected: stats.Disconnected,
+			Lists:        make([]list, 0, len(ids)),
+		}
+		for _, id := range ids {
+			o.Lists = append(o.Lists, list{
+				ID:          id,
+				Name:        names[id],
+				Subscribers: stats.Subscribers[id],
+				Watchers:    s.watchers.get(id),
+			})
+		}
+		sort.Slice(o.Lists, func(i, j int) bool { return o.Lists[i].Subscribers > o.Lists[j].Subscribers })
+		return c.JSON(http.StatusOK, o)
+	}
+}
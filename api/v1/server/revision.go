@@ -0,0 +1,247 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+	"gorm.io/gorm"
+)
+
+const (
+	revisionCreated  = "created"
+	revisionUpdated  = "updated"
+	revisionDeleted  = "deleted"
+	revisionRestored = "restored"
+
+	// revisionPageSize is the default and revisionPageLimit the maximum
+	// number of revisions per page.
+	revisionPageSize  = 50
+	revisionPageLimit = 200
+)
+
+// recordRevision records the change of an entry from before to after within
+// the transaction tx together with the client making it. A move is recorded
+// with both lists, so it shows up in the activity of each.
+func recordRevision(c echo.Context, tx *gorm.DB, action string, before, after *database.Entry) error {
+	r := database.EntryRevision{
+		Action:   action,
+		ClientIP: c.RealIP(),
+	}
+	for _, e := range []*database.Entry{before, after} {
+		if e == nil {
+			continue
+		}
+		r.EntryID = e.ID
+		r.ListID = e.ListID
+	}
+	if before != nil && after != nil && before.ListID != after.ListID {
+		r.FromListID = &before.ListID
+	}
+
+	marshal := func(e *database.Entry) (*string, error) {
+		if e == nil {
+			return nil, nil
+		}
+		b, err := json.Marshal(newEntryPayload(*e))
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal JSON, %w", err)
+		}
+		s := string(b)
+		return &s, nil
+	}
+	var err error
+	if r.Before, err = marshal(before); err != nil {
+		return err
+	}
+	if r.After, err = marshal(after); err != nil {
+		return err
+	}
+
+	user, err := userFromContext(c)
+	if err != nil {
+		return err
+	}
+	if user != nil {
+		r.UserID = &user.ID
+	} else if a, err := accessFromContext(c); err == nil && a.Share != nil {
+		r.ShareID = &a.Share.ID
+	}
+
+	if err := tx.Create(&r).Error; err != nil {
+		return fmt.Errorf("unable to record revision, %w", err)
+	}
+	return nil
+}
+
+// revision is a change of an entry as returned by the API.
+type revision struct {
+	ID      int64
+	EntryID uuid.UUID
+	ListID  uuid.UUID
+	// FromListID is the list the entry was moved from, empty if it was not
+	// moved.
+	FromListID *uuid.UUID
+	Time       time.Time
+	Action     string
+	Before     json.RawMessage
+	After      json.RawMessage
+	UserID     *uuid.UUID
+	UserName   string
+	ShareID    *uuid.UUID
+	// ClientIP is only shown to the owner of the list.
+	ClientIP string `json:",omitempty"`
+}
+
+// revisionPage is a page of revisions, the most recent first.
+type revisionPage struct {
+	Revisions []revision
+	// Next is the Before parameter for the next page, missing on the last
+	// page.
+	Next int64 `json:",omitempty"`
+}
+
+// revisions returns a page of the revisions matching the query q according to
+// the pagination parameters of the request. roles are the roles of the client
+// in the lists of the revisions.
+func (s server) revisions(c echo.Context, q *gorm.DB, roles map[uuid.UUID]database.Role) error {
+	type input struct {
+		// Before is the ID of the revision to continue after.
+		Before int64 `query:"Before"`
+		Limit  int   `query:"Limit"`
+	}
+	var i input
+	if err := c.Bind(&i); err != nil {
+		return echo.ErrBadRequest.SetInternal(err)
+	}
+	if i.Limit <= 0 {
+		i.Limit = revisionPageSize
+	}
+	i.Limit = min(i.Limit, revisionPageLimit)
+
+	if i.Before > 0 {
+		q = q.Where("id < ?", i.Before)
+	}
+	// Get one more to know whether there is a next page
+	rs := []database.EntryRevision{}
+	if err := q.Order("id desc").Limit(i.Limit + 1).Find(&rs).Error; err != nil {
+		return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get revisions from database, %w", err))
+	}
+	page := revisionPage{Revisions: make([]revision, 0, len(rs))}
+	if len(rs) > i.Limit {
+		rs = rs[:i.Limit]
+		page.Next = rs[len(rs)-1].ID
+	}
+
+	userIDs := []uuid.UUID{}
+	for _, r := range rs {
+		if r.UserID != nil {
+			userIDs = append(userIDs, *r.UserID)
+		}
+	}
+	names := map[uuid.UUID]string{}
+	if len(userIDs) > 0 {
+		us := []database.User{}
+		if err := s.db.Unscoped().Select("id", "name").Find(&us, userIDs).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get users from database, %w", err))
+		}
+		for _, u := range us {
+			names[u.ID] = u.Name
+		}
+	}
+
+	isOwner := func(listID *uuid.UUID) bool {
+		return listID != nil && roles[*listID].Allows(database.RoleOwner)
+	}
+	raw := func(s *string) json.RawMessage {
+		if s == nil {
+			return nil
+		}
+		return json.RawMessage(*s)
+	}
+	for _, r := range rs {
+		rev := revision{
+			ID:         r.ID,
+			EntryID:    r.EntryID,
+			ListID:     r.ListID,
+			FromListID: r.FromListID,
+			Time:       r.CreatedAt,
+			Action:     r.Action,
+			Before:     raw(r.Before),
+			After:      raw(r.After),
+			UserID:     r.UserID,
+			ShareID:    r.ShareID,
+		}
+		if r.UserID != nil {
+			rev.UserName = names[*r.UserID]
+		}
+		if isOwner(&r.ListID) || isOwner(r.FromListID) {
+			rev.ClientIP = r.ClientIP
+		}
+		page.Revisions = append(page.Revisions, rev)
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// entryHistory returns the changes of an entry, deleted entries included.
+// Entries can be moved between lists, so only the changes in lists the client
+// can access are returned.
+func (s server) entryHistory() echo.HandlerFunc {
+	type input struct {
+		ID uuid.UUID `param:"ID"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		rs := []database.EntryRevision{}
+		if err := s.db.Select("list_id", "from_list_id").Where("entry_id = ?", i.ID).Find(&rs).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get revisions from database, %w", err))
+		}
+		listIDs := map[uuid.UUID]bool{}
+		for _, r := range rs {
+			listIDs[r.ListID] = true
+			if r.FromListID != nil {
+				listIDs[*r.FromListID] = true
+			}
+		}
+		roles := map[uuid.UUID]database.Role{}
+		accessible := []uuid.UUID{}
+		for id := range listIDs {
+			var l database.List
+			if err := s.db.First(&l, id).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					continue
+				}
+				return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get list %v, %w", id, err))
+			}
+			a, err := s.resolveAccess(c, l)
+			if err != nil {
+				return echo.ErrInternalServerError.SetInternal(err)
+			}
+			if a.Role.Allows(database.RoleViewer) {
+				roles[id] = a.Role
+				accessible = append(accessible, id)
+			}
+		}
+		return s.revisions(c, s.db.Where("entry_id = ? AND (list_id IN ? OR from_list_id IN ?)", i.ID, accessible, accessible), roles)
+	}
+}
+
+// listActivity returns the changes of all entries of the list.
+func (s server) listActivity() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return s.revisions(c, s.db.Where("list_id = ? OR from_list_id = ?", a.List.ID, a.List.ID), map[uuid.UUID]database.Role{a.List.ID: a.Role})
+	}
+}
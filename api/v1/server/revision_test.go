@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/shaardie/listinator/database"
+)
+
+func TestHistorySurvivesListDelete(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, e := env.list(owner)
+	if rec := env.do(http.MethodPatch, fmt.Sprintf("/api/v1/entries/%v", e.ID), map[string]string{"Number": "2"}, owner); rec.Code != http.StatusOK {
+		t.Fatalf("updating entry failed with %v", rec.Code)
+	}
+	if rec := env.do(http.MethodDelete, fmt.Sprintf("/api/v1/lists/%v", l.ID), nil, owner); rec.Code != http.StatusOK {
+		t.Fatalf("deleting list failed with %v", rec.Code)
+	}
+
+	// restore the list and its entries
+	if err := env.db.Unscoped().Model(&database.List{}).Where("id = ?", l.ID).Update("deleted_at", nil).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := env.db.Unscoped().Model(&database.Entry{}).Where("list_id = ?", l.ID).Update("deleted_at", nil).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	rec := env.do(http.MethodGet, fmt.Sprintf("/api/v1/entries/%v/history", e.ID), nil, owner)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("getting history failed with %v", rec.Code)
+	}
+	var page revisionPage
+	env.decode(rec, &page)
+	var actions []string
+	for _, r := range page.Revisions {
+		actions = append(actions, r.Action)
+	}
+	if got := fmt.Sprint(actions); got != "[updated created]" {
+		t.Errorf("got history %v, want [updated created]", got)
+	}
+}
+
+// revisionPage gets the revisions at the path.
+func (env *testEnv) revisionPage(path string, cookie *http.Cookie) revisionPage {
+	env.t.Helper()
+	rec := env.do(http.MethodGet, path, nil, cookie)
+	if rec.Code != http.StatusOK {
+		env.t.Fatalf("getting %v failed with %v", path, rec.Code)
+	}
+	var page revisionPage
+	env.decode(rec, &page)
+	return page
+}
+
+func TestMoveRevision(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	from, e := env.list(owner)
+	to, _ := env.list(owner)
+	if rec := env.patch(e, fmt.Sprintf(`{"ListID":%q}`, to.ID), owner); rec.Code != http.StatusOK {
+		t.Fatalf("moving entry failed with %v", rec.Code)
+	}
+
+	// the move is in the activity of both lists
+	for name, l := range map[string]database.List{"source": from, "target": to} {
+		t.Run(name, func(t *testing.T) {
+			page := env.revisionPage(fmt.Sprintf("/api/v1/lists/%v/activity", l.ID), owner)
+			r := page.Revisions[0]
+			if r.EntryID != e.ID || r.Action != revisionUpdated {
+				t.Fatalf("got %v of %v as last activity, want move of %v", r.Action, r.EntryID, e.ID)
+			}
+			if r.ListID != to.ID || r.FromListID == nil || *r.FromListID != from.ID {
+				t.Errorf("got move from %v to %v, want from %v to %v", r.FromListID, r.ListID, from.ID, to.ID)
+			}
+			if r.ClientIP == "" {
+				t.Error("client IP not shown to the owner")
+			}
+		})
+	}
+}
+
+func TestHistoryAccess(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	other := env.user("other")
+	viewer := env.user("viewer")
+	l, _ := env.list(owner)
+	private, e := env.list(other)
+	if rec := env.patch(e, `{"Number":"2"}`, other); rec.Code != http.StatusOK {
+		t.Fatalf("updating entry failed with %v", rec.Code)
+	}
+	for name, role := range map[string]database.Role{"other": database.RoleEditor, "viewer": database.RoleViewer} {
+		rec := env.do(http.MethodPost, fmt.Sprintf("/api/v1/lists/%v/members", l.ID), map[string]any{"Name": name, "Role": role}, owner)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("adding member failed with %v", rec.Code)
+		}
+	}
+	// other moves the entry from the private list into the list of owner
+	if rec := env.patch(e, fmt.Sprintf(`{"ListID":%q}`, l.ID), other); rec.Code != http.StatusOK {
+		t.Fatalf("moving entry failed with %v", rec.Code)
+	}
+
+	path := fmt.Sprintf("/api/v1/entries/%v/history", e.ID)
+	tests := []struct {
+		name    string
+		cookie  *http.Cookie
+		want    string
+		showsIP bool
+	}{
+		// owner only sees the move into the list
+		{"owner of target", owner, "[updated]", true},
+		{"owner of source", other, "[updated updated created]", true},
+		{"viewer of target", viewer, "[updated]", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := env.revisionPage(path, tt.cookie)
+			var actions []string
+			for _, r := range page.Revisions {
+				actions = append(actions, r.Action)
+				if r.ListID != l.ID && r.ListID != private.ID {
+					t.Errorf("revision in unknown list %v", r.ListID)
+				}
+				if (r.ClientIP != "") != tt.showsIP {
+					t.Errorf("got client IP %q of revision in %v", r.ClientIP, r.ListID)
+				}
+			}
+			if got := fmt.Sprint(actions); got != tt.want {
+				t.Errorf("got history %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
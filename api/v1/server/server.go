@@ -1,43 +1,226 @@
 package server
 
 import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
 	"github.com/shaardie/listinator/pubsub"
 	"gorm.io/gorm"
 )
 
+const (
+	// PubSubMemory delivers events only within this instance.
+	PubSubMemory = "memory"
+	// PubSubDatabase delivers events through the shared database to all
+	// instances using it.
+	PubSubDatabase = "database"
+
+	// pubSubPollInterval is the interval in which PubSubDatabase polls for
+	// events of other instances.
+	pubSubPollInterval = 500 * time.Millisecond
+)
+
+// Config contains the settings of the server.
+type Config struct {
+	// SessionIdleTimeout is the duration after which unused sessions expire.
+	SessionIdleTimeout time.Duration
+	// PubSub is the backend delivering the events, PubSubMemory or
+	// PubSubDatabase. Defaults to PubSubMemory.
+	PubSub string
+	// SlowConsumer is the name of the pubsub.Policy for clients, which can not
+	// keep up with the events. Defaults to disconnect.
+	SlowConsumer string
+	// TrashRetention is the duration after which deleted entries are purged
+	// and can not be restored anymore. Zero keeps them forever.
+	TrashRetention time.Duration
+}
+
 type server struct {
-	db *gorm.DB
+	db  *gorm.DB
+	cfg Config
+
+	// loginThrottle slows down brute-force attacks on the login
+	loginThrottle *loginThrottle
 
-	// Entry
-	entryPubSub pubsub.PubSub[uuid.UUID, entryEvent]
+	// eventPubSub delivers the events of the lists
+	eventPubSub pubsub.PubSub[uuid.UUID, event]
+	// eventMu serializes writing to the event log and publishing the events
+	eventMu *sync.Mutex
+	// watchers are the clients receiving the events
+	watchers *watchers
+	// presencePubSub informs the watchers of a list about each other
+	presencePubSub pubsub.PubSub[uuid.UUID, presenceEvent]
+
+	// shutdown is closed, when the server is shutting down, so the streaming
+	// clients are told to reconnect
+	shutdown     chan struct{}
+	shutdownOnce *sync.Once
+	// running tracks the WebSocket handlers and the trash purge, the http
+	// server does not wait for them on shutdown
+	running *sync.WaitGroup
 }
 
-func New(db *gorm.DB) server {
-	return server{
-		db:          db,
-		entryPubSub: pubsub.New[uuid.UUID, entryEvent](16),
+func New(db *gorm.DB, cfg Config) (server, error) {
+	opts := pubsub.Options[event]{
+		Buffer: 16,
+		Merge:  mergeEvents,
+	}
+	if cfg.SlowConsumer != "" {
+		p, err := pubsub.ParsePolicy(cfg.SlowConsumer)
+		if err != nil {
+			return server{}, err
+		}
+		opts.Policy = p
+	}
+
+	var eventPubSub pubsub.PubSub[uuid.UUID, event]
+	switch cfg.PubSub {
+	case "", PubSubMemory:
+		eventPubSub = pubsub.NewMemory[uuid.UUID](opts)
+	case PubSubDatabase:
+		cursor, err := lastEventID(db)
+		if err != nil {
+			return server{}, err
+		}
+		eventPubSub = pubsub.NewPolling(pollEvents(db), cursor, pubSubPollInterval, opts)
+	default:
+		return server{}, fmt.Errorf("unknown pubsub %q", cfg.PubSub)
+	}
+
+	s := server{
+		db:            db,
+		cfg:           cfg,
+		loginThrottle: newLoginThrottle(),
+		eventPubSub:   eventPubSub,
+		eventMu:       &sync.Mutex{},
+		watchers:      newWatchers(),
+		presencePubSub: pubsub.NewMemory[uuid.UUID](pubsub.Options[presenceEvent]{
+			Buffer: 16,
+			Policy: pubsub.Disconnect,
+		}),
+		shutdown:     make(chan struct{}),
+		shutdownOnce: &sync.Once{},
+		running:      &sync.WaitGroup{},
+	}
+	if cfg.TrashRetention > 0 {
+		s.running.Add(1)
+		go func() {
+			defer s.running.Done()
+			s.runTrashPurge()
+		}()
+	}
+	return s, nil
+}
+
+// Shutdown tells all clients streaming events to reconnect and stops polling
+// for events and purging the trash. It does not wait for the streams to end,
+// this is up to the shutdown of the http server and Wait.
+func (s server) Shutdown() {
+	s.shutdownOnce.Do(func() {
+		close(s.shutdown)
+		if p, ok := s.eventPubSub.(interface{ Close() }); ok {
+			p.Close()
+		}
+	})
+}
+
+// Wait waits for the WebSocket handlers and the trash purge to end after
+// Shutdown, so the database can be closed afterwards. Call it after the http
+// server is shut down, so no new WebSocket connections come in.
+func (s server) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
 func (s server) SetupRoutes(g *echo.Group) {
 	// entries
-	g.GET("/entries", s.entryList())
-	g.POST("/entries", s.entryCreate())
-	g.GET("/entries/:id", s.entryGet())
-	g.PUT("/entries/:id", s.entryUpdate())
-	g.DELETE("/entries/:id", s.entryDelete())
-	g.GET("/entries/events", s.entryGetEvents())
+	g.GET("/entries", s.listAccessMiddleware(database.RoleViewer, listIDFromQuery("ListID"), s.entryList()))
+	g.POST("/entries", s.listAccessMiddleware(database.RoleEditor, listIDFromBody(func(i *entryInput) uuid.UUID { return i.ListID }), s.entryCreate()))
+	g.POST("/entries/batch", s.listAccessMiddleware(database.RoleEditor, listIDFromBody(func(i *batchInput) uuid.UUID { return i.ListID }), s.entryBatch()))
+	g.GET("/entries/:id", s.listAccessMiddleware(database.RoleViewer, s.listIDFromEntryParam("id"), s.entryGet()))
+	g.PUT("/entries/:id", s.listAccessMiddleware(database.RoleEditor, s.listIDFromEntryParam("id"), s.entryUpdate()))
+	g.PATCH("/entries/:id", s.listAccessMiddleware(database.RoleEditor, s.listIDFromEntryParam("id"), s.entryPatch()))
+	g.POST("/entries/:id/restore", s.listAccessMiddleware(database.RoleEditor, s.listIDFromDeletedEntryParam("id"), s.entryRestore()))
+	g.DELETE("/entries/:id", s.listAccessMiddleware(database.RoleEditor, s.listIDFromEntryParam("id"), s.entryDelete()))
+	g.GET("/entries/:id/history", s.listAccessMiddleware(database.RoleViewer, s.listIDFromDeletedEntryParam("id"), s.entryHistory()))
+	g.GET("/entries/events", s.listAccessMiddleware(database.RoleViewer, listIDFromQuery("ListID"), s.entryGetEvents()))
 
 	// lists
-	g.POST("/lists", s.listCreate())
+	g.GET("/lists", s.tokenOrSessionMiddleware(s.listList()))
+	g.POST("/lists", s.tokenOrSessionMiddleware(s.listCreate()))
+	g.GET("/lists/:id", s.listAccessMiddleware(database.RoleViewer, listIDFromParam("id"), s.listGet()))
+	g.PUT("/lists/:id", s.listAccessMiddleware(database.RoleEditor, listIDFromParam("id"), s.listUpdate()))
+	g.DELETE("/lists/:id", s.listAccessMiddleware(database.RoleOwner, listIDFromParam("id"), s.listDelete()))
+	g.POST("/lists/:id/clear-bought", s.listAccessMiddleware(database.RoleEditor, listIDFromParam("id"), s.listClearBought()))
+	g.POST("/lists/:id/uncheck-all", s.listAccessMiddleware(database.RoleEditor, listIDFromParam("id"), s.listUncheckAll()))
+	g.POST("/lists/:id/merge-duplicates", s.listAccessMiddleware(database.RoleEditor, listIDFromParam("id"), s.listMergeDuplicates()))
+	g.POST("/lists/:id/operations/:operationID/undo", s.listAccessMiddleware(database.RoleEditor, listIDFromParam("id"), s.listOperationUndo()))
+	g.GET("/lists/:id/activity", s.listAccessMiddleware(database.RoleViewer, listIDFromParam("id"), s.listActivity()))
+	g.GET("/lists/:id/trash", s.listAccessMiddleware(database.RoleViewer, listIDFromParam("id"), s.listTrash()))
+	g.GET("/lists/:id/presence", s.listAccessMiddleware(database.RoleViewer, listIDFromParam("id"), s.listPresence()))
+	g.GET("/lists/:id/ws", s.listAccessMiddleware(database.RoleViewer, listIDFromParam("id"), s.listWebSocket()))
+
+	// list members
+	g.GET("/lists/:id/members", s.listAccessMiddleware(database.RoleViewer, listIDFromParam("id"), s.memberList()))
+	g.POST("/lists/:id/members", s.listAccessMiddleware(database.RoleOwner, listIDFromParam("id"), s.memberCreate()))
+	g.PUT("/lists/:id/members/:userID", s.listAccessMiddleware(database.RoleOwner, listIDFromParam("id"), s.memberUpdate()))
+	g.DELETE("/lists/:id/members/:userID", s.listAccessMiddleware(database.RoleViewer, listIDFromParam("id"), s.memberDelete()))
+
+	// list shares
+	g.GET("/lists/:id/shares", s.listAccessMiddleware(database.RoleOwner, listIDFromParam("id"), s.shareList()))
+	g.POST("/lists/:id/shares", s.listAccessMiddleware(database.RoleOwner, listIDFromParam("id"), s.shareCreate()))
+	g.DELETE("/lists/:id/shares/:shareID", s.listAccessMiddleware(database.RoleOwner, listIDFromParam("id"), s.shareDelete()))
+	g.POST("/shares/redeem", s.shareRedeem())
+
+	// users
+	g.GET("/users", s.adminMiddleware(s.userList()))
+	g.POST("/users", s.adminMiddleware(s.userCreate()))
+	g.GET("/users/:id", s.adminMiddleware(s.userGet()))
+	g.PUT("/users/:id", s.adminMiddleware(s.userUpdate()))
+	g.DELETE("/users/:id", s.adminMiddleware(s.userDelete()))
+	g.POST("/users/:id/unlock", s.adminMiddleware(s.userUnlock()))
+	g.DELETE("/users/:id/totp", s.adminMiddleware(s.userTOTPReset()))
+
+	// invitations
+	g.GET("/invitations", s.adminMiddleware(s.invitationList()))
+	g.POST("/invitations", s.adminMiddleware(s.invitationCreate()))
+	g.DELETE("/invitations/:id", s.adminMiddleware(s.invitationDelete()))
+	g.POST("/register", s.register())
+
+	// API tokens
+	g.GET("/tokens", s.sessionMiddleware(s.tokenList()))
+	g.POST("/tokens", s.sessionMiddleware(s.tokenCreate()))
+	g.DELETE("/tokens/:id", s.sessionMiddleware(s.tokenDelete()))
+
+	// admin
+	g.GET("/admin/realtime", s.adminMiddleware(s.adminRealtime()))
 
 	// types
 	g.GET("/types", s.typeList())
+	g.PUT("/types/:id", s.adminMiddleware(s.typeUpdate()))
 
 	// Login, Logout and stuff
 	g.GET("/session", s.sessionMiddleware(s.sessionGet()))
 	g.POST("/session", s.sessionCreate())
 	g.DELETE("/session", s.sessionDelete())
+	g.POST("/session/verify", s.sessionVerify())
+	g.GET("/session/all", s.sessionMiddleware(s.sessionList()))
+	g.DELETE("/session/:id", s.sessionMiddleware(s.sessionRevoke()))
+	g.POST("/session/totp", s.sessionMiddleware(s.totpEnroll()))
+	g.POST("/session/totp/confirm", s.sessionMiddleware(s.totpConfirm()))
+	g.DELETE("/session/totp", s.sessionMiddleware(s.totpDisable()))
 }
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+	"gorm.io/gorm"
+)
+
+// testEnv is a server with its own database to test the API with.
+type testEnv struct {
+	t  *testing.T
+	db *gorm.DB
+	s  server
+	e  *echo.Echo
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	return newTestEnvWithConfig(t, Config{SessionIdleTimeout: time.Hour})
+}
+
+// newTestEnvWithConfig creates a test environment with the server
+// configuration cfg.
+func newTestEnvWithConfig(t *testing.T, cfg Config) *testEnv {
+	t.Helper()
+	db, err := database.Init(filepath.Join(t.TempDir(), "listinator.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.Shutdown()
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	e := echo.New()
+	e.Use(session.Middleware(sessions.NewCookieStore([]byte("secret"))))
+	s.SetupRoutes(e.Group("/api/v1"))
+	return &testEnv{t: t, db: db, s: s, e: e}
+}
+
+// user creates a user and returns the cookie of a session of it.
+func (env *testEnv) user(name string) *http.Cookie {
+	env.t.Helper()
+	hash, err := database.HashPassword("secret")
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	if err := env.db.Create(&database.User{Name: name, PasswordHash: hash}).Error; err != nil {
+		env.t.Fatal(err)
+	}
+
+	rec := env.do(http.MethodPost, "/api/v1/session", map[string]string{"name": name, "password": "secret"}, nil)
+	if rec.Code != http.StatusOK {
+		env.t.Fatalf("login of %v failed with %v", name, rec.Code)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == sessionKey {
+			return cookie
+		}
+	}
+	env.t.Fatalf("no session cookie for %v", name)
+	return nil
+}
+
+// do sends a request with body as JSON and the session cookie, which may be
+// nil for anonymous requests.
+func (env *testEnv) do(method, path string, body any, cookie *http.Cookie) *httptest.ResponseRecorder {
+	env.t.Helper()
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			env.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	env.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode decodes the JSON body of the response into v.
+func (env *testEnv) decode(rec *httptest.ResponseRecorder, v any) {
+	env.t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		env.t.Fatalf("unable to decode %q, %v", rec.Body.String(), err)
+	}
+}
+
+// list creates a list with an entry as the user of the cookie.
+func (env *testEnv) list(cookie *http.Cookie) (database.List, database.Entry) {
+	env.t.Helper()
+	rec := env.do(http.MethodPost, "/api/v1/lists", map[string]string{"Name": "groceries"}, cookie)
+	if rec.Code != http.StatusCreated {
+		env.t.Fatalf("creating list failed with %v", rec.Code)
+	}
+	var l database.List
+	env.decode(rec, &l)
+
+	rec = env.do(http.MethodPost, "/api/v1/entries", map[string]any{"Name": "milk", "ListID": l.ID}, cookie)
+	if rec.Code != http.StatusCreated {
+		env.t.Fatalf("creating entry failed with %v", rec.Code)
+	}
+	var e database.Entry
+	env.decode(rec, &e)
+	return l, e
+}
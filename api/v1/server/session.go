@@ -3,22 +3,37 @@ package server
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/shaardie/listinator/database"
-	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 
 	"github.com/gorilla/sessions"
 	"github.com/labstack/echo-contrib/session"
 )
 
 const (
-	sessionKey = "listinator_session"
-	uuidKey    = "uuid"
-	userKey    = "user"
+	sessionKey        = "listinator_session"
+	sessionIDKey      = "id"
+	userKey           = "user"
+	currentSessionKey = "session"
+	sharesKey         = "shares"
 )
 
+func newSessionOptions() *sessions.Options {
+	return &sessions.Options{
+		Path:     "/",
+		MaxAge:   86400 * 365, // one year
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
 func (s server) sessionCreate() echo.HandlerFunc {
 	type input struct {
 		Name     string `json:"name"`
@@ -34,81 +49,260 @@ func (s server) sessionCreate() echo.HandlerFunc {
 			return echo.ErrBadRequest
 		}
 
+		ip := c.RealIP()
+		if wait := s.loginThrottle.wait(ip); wait > 0 {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			return echo.ErrTooManyRequests.SetInternal(fmt.Errorf("too many failed logins from %v", ip))
+		}
+
+		// Unknown and locked users get the same response in the same time as
+		// a wrong password, so it is not possible to find out which users exist.
 		u := database.User{}
-		if err := s.db.Find(&u, "name = ?", i.Name).Error; err != nil {
-			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("unable to get user %v from database, %w", i.Name, err))
+		err := s.db.First(&u, "name = ?", i.Name).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			database.SimulatePasswordCheck(i.Password)
+			s.loginThrottle.fail(ip, i.Name)
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("unknown user %v", i.Name))
+		}
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get user %v from database, %w", i.Name, err))
+		}
+		if u.Locked(time.Now()) {
+			database.SimulatePasswordCheck(i.Password)
+			s.loginThrottle.fail(ip, i.Name)
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("user %v is locked until %v", i.Name, u.LockedUntil))
 		}
 
-		if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(i.Password)); err != nil {
+		if err := u.CheckPassword(i.Password); err != nil {
+			s.loginThrottle.fail(ip, i.Name)
+			if lockErr := s.recordFailedLogin(u); lockErr != nil {
+				return echo.ErrInternalServerError.SetInternal(lockErr)
+			}
 			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("wrong password for user %v, %w", i.Name, err))
 		}
-
-		sess, err := session.Get(sessionKey, c)
-		if err != nil {
-			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get session, %w", err))
+		if u.Disabled {
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("user %v is disabled", i.Name))
 		}
-		sess.Options = &sessions.Options{
-			Path:     "/",
-			MaxAge:   86400 * 365, // one year
-			HttpOnly: true,
-			Secure:   true,
+
+		// The second factor is checked in a second step, see sessionVerify
+		if u.TOTPEnabled {
+			return s.startPendingSession(c, u)
 		}
-		sess.Values[uuidKey] = u.ID.String()
-		if err := sess.Save(c.Request(), c.Response()); err != nil {
-			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to save session, %w", err))
+
+		return s.completeLogin(c, u)
+	}
+}
+
+// completeLogin resets the failed logins of the user u, also the ones from
+// the IP address, after a successful login and starts the session.
+func (s server) completeLogin(c echo.Context, u database.User) error {
+	s.loginThrottle.succeed(c.RealIP(), u.Name)
+	if u.FailedLogins > 0 || u.LockedUntil != nil {
+		if err := s.db.Model(&u).Select("failed_logins", "locked_until").Updates(map[string]any{"failed_logins": 0, "locked_until": nil}).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to reset failed logins, %w", err))
 		}
+	}
+	return s.startSession(c, u)
+}
 
+// recordFailedLogin counts a failed login of the user u and locks the user,
+// if there were too many. Every further failed login doubles the lockout.
+func (s server) recordFailedLogin(u database.User) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&u).UpdateColumn("failed_logins", gorm.Expr("failed_logins + 1")).Error; err != nil {
+			return fmt.Errorf("unable to count failed login, %w", err)
+		}
+		if err := tx.Select("failed_logins").First(&u, u.ID).Error; err != nil {
+			return fmt.Errorf("unable to get failed logins, %w", err)
+		}
+		if u.FailedLogins < userLockoutThreshold {
+			return nil
+		}
+		lockedUntil := time.Now().Add(backoff(userLockoutBase, userLockoutMax, u.FailedLogins-userLockoutThreshold))
+		if err := tx.Model(&u).UpdateColumn("locked_until", lockedUntil).Error; err != nil {
+			return fmt.Errorf("unable to lock user, %w", err)
+		}
 		return nil
+	})
+}
+
+// startSession creates a new session for the user u in the database and
+// stores its id in the session cookie.
+func (s server) startSession(c echo.Context, u database.User) error {
+	dbSess := database.Session{
+		UserID:     u.ID,
+		UserAgent:  c.Request().UserAgent(),
+		IP:         c.RealIP(),
+		LastSeenAt: time.Now(),
+	}
+	if err := s.db.Create(&dbSess).Error; err != nil {
+		return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to create session in database, %w", err))
+	}
+
+	sess, err := session.Get(sessionKey, c)
+	if err != nil {
+		return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get session, %w", err))
 	}
+	sess.Options = newSessionOptions()
+	sess.Values[sessionIDKey] = dbSess.ID.String()
+	delete(sess.Values, pendingUserKey)
+	delete(sess.Values, pendingUntilKey)
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to save session, %w", err))
+	}
+	return nil
 }
 
+// revokeUserSessions revokes all sessions of the user with the given id.
+func revokeUserSessions(tx *gorm.DB, userID uuid.UUID) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&database.Session{}).Error; err != nil {
+		return fmt.Errorf("unable to revoke sessions of user %v, %w", userID, err)
+	}
+	return nil
+}
+
+// sessionMiddleware authenticates the request with the session cookie and
+// sets the user in the context. API and share tokens are rejected, they are
+// only good for the lists and entries, see tokenOrSessionMiddleware and
+// optionalSessionMiddleware.
 func (s server) sessionMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		// Get uuid from session cookie
+		if _, ok := bearerToken(c); ok {
+			return echo.ErrUnauthorized.SetInternal(errors.New("API tokens are not accepted for this route"))
+		}
+		if c.Request().Header.Get(headerShareToken) != "" {
+			return echo.ErrUnauthorized.SetInternal(errors.New("share tokens are not accepted for this route"))
+		}
+
+		// Get session id from session cookie
 		sess, err := session.Get(sessionKey, c)
 		if err != nil {
 			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get session, %w", err))
 		}
-		uuidAny, ok := sess.Values[uuidKey]
+		idAny, ok := sess.Values[sessionIDKey]
 		if !ok {
 			return echo.ErrUnauthorized
 		}
 
 		// Check if string
-		uuidStr, ok := uuidAny.(string)
+		idStr, ok := idAny.(string)
 		if !ok {
-			return echo.ErrUnauthorized.SetInternal(errors.New("uuid in cookie is no string"))
+			return echo.ErrUnauthorized.SetInternal(errors.New("session id in cookie is no string"))
 		}
 
 		// Parse UUID
-		uuidObj, err := uuid.Parse(uuidStr)
+		id, err := uuid.Parse(idStr)
 		if err != nil {
-			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("unable to parse uuid, %w", err))
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("unable to parse session id, %w", err))
+		}
+
+		// Revoked sessions are soft deleted and therefor not found
+		var dbSess database.Session
+		if err := s.db.Preload("User").First(&dbSess, id).Error; err != nil {
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("unable to get session from database, %w", err))
+		}
+		if time.Since(dbSess.LastSeenAt) > s.cfg.SessionIdleTimeout {
+			if err := s.db.Delete(&dbSess).Error; err != nil {
+				return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to revoke idle session, %w", err))
+			}
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("session %v idle for too long", dbSess.ID))
 		}
 
-		u := database.User{
-			Model: database.Model{
-				ID: uuidObj,
-			},
+		u := dbSess.User
+		if u.ID == uuid.Nil {
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("user of session %v not found", dbSess.ID))
+		}
+		if u.Disabled {
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("user %v is disabled", u.Name))
 		}
-		if err := s.db.First(&u).Error; err != nil {
-			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("unable to get user from database, %w", err))
+
+		// Only update the session from time to time to spare the database
+		if time.Since(dbSess.LastSeenAt) > time.Minute {
+			dbSess.LastSeenAt = time.Now()
+			dbSess.IP = c.RealIP()
+			dbSess.UserAgent = c.Request().UserAgent()
+			if err := s.db.Model(&dbSess).Select("last_seen_at", "ip", "user_agent").Updates(&dbSess).Error; err != nil {
+				return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to update session, %w", err))
+			}
 		}
 
 		c.Set(userKey, &u)
+		c.Set(currentSessionKey, &dbSess)
 		return next(c)
 	}
 }
 
+// tokenOrSessionMiddleware authenticates the request either with an API
+// token or with the session cookie and sets the user in the context.
+func (s server) tokenOrSessionMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if token, ok := bearerToken(c); ok {
+			return s.tokenMiddleware(token, next)(c)
+		}
+		return s.sessionMiddleware(next)(c)
+	}
+}
+
+// optionalSessionMiddleware behaves like tokenOrSessionMiddleware if there is
+// a token or session, but lets anonymous requests through without setting a
+// user. Requests with a share token are anonymous, the share token replaces
+// the session.
+func (s server) optionalSessionMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if token, ok := bearerToken(c); ok {
+			return s.tokenMiddleware(token, next)(c)
+		}
+		if _, ok := shareToken(c); ok {
+			return next(c)
+		}
+
+		sess, err := session.Get(sessionKey, c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get session, %w", err))
+		}
+		if _, ok := sess.Values[sessionIDKey]; !ok {
+			return next(c)
+		}
+		return s.sessionMiddleware(next)(c)
+	}
+}
+
+// userFromContext returns the user set by the session middlewares, or
+// nil, if there is none.
+func userFromContext(c echo.Context) (*database.User, error) {
+	userAny := c.Get(userKey)
+	if userAny == nil {
+		return nil, nil
+	}
+	user, ok := userAny.(*database.User)
+	if !ok {
+		return nil, fmt.Errorf("wrong type %T context", userAny)
+	}
+	return user, nil
+}
+
+// adminMiddleware behaves like sessionMiddleware, but only lets admins through.
+func (s server) adminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return s.sessionMiddleware(func(c echo.Context) error {
+		user, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		if !user.IsAdmin {
+			return echo.ErrForbidden.SetInternal(fmt.Errorf("user %v is no admin", user.Name))
+		}
+		return next(c)
+	})
+}
+
 func (s server) sessionGet() echo.HandlerFunc {
 	return func(c echo.Context) error {
-		userAny := c.Get(userKey)
-		if userAny == nil {
-			return echo.ErrUnauthorized
+		user, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
 		}
-		user, ok := userAny.(*database.User)
-		if !ok {
-			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("wrong type %T context", userAny))
+		if user == nil {
+			return echo.ErrUnauthorized
 		}
 		return c.JSON(200, user)
 	}
@@ -120,6 +314,16 @@ func (s server) sessionDelete() echo.HandlerFunc {
 		if err != nil {
 			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get session, %w", err))
 		}
+
+		// Revoke the session in the database, so the cookie is useless, even if it is not deleted
+		if idStr, ok := sess.Values[sessionIDKey].(string); ok {
+			if id, err := uuid.Parse(idStr); err == nil {
+				if err := s.db.Delete(&database.Session{}, id).Error; err != nil {
+					return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to revoke session, %w", err))
+				}
+			}
+		}
+
 		sess.Options.MaxAge = -1
 		if err := sess.Save(c.Request(), c.Response()); err != nil {
 			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to save session, %w", err))
@@ -127,3 +331,68 @@ func (s server) sessionDelete() echo.HandlerFunc {
 		return nil
 	}
 }
+
+func (s server) sessionList() echo.HandlerFunc {
+	type output struct {
+		ID         uuid.UUID
+		CreatedAt  time.Time
+		UserAgent  string
+		IP         string
+		LastSeenAt time.Time
+		Current    bool
+	}
+	return func(c echo.Context) error {
+		user, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		current, _ := c.Get(currentSessionKey).(*database.Session)
+
+		ss := []database.Session{}
+		if err := s.db.
+			Where("user_id = ? AND last_seen_at > ?", user.ID, time.Now().Add(-s.cfg.SessionIdleTimeout)).
+			Order("last_seen_at desc").
+			Find(&ss).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get sessions from database, %w", err))
+		}
+
+		res := make([]output, 0, len(ss))
+		for _, dbSess := range ss {
+			res = append(res, output{
+				ID:         dbSess.ID,
+				CreatedAt:  dbSess.CreatedAt,
+				UserAgent:  dbSess.UserAgent,
+				IP:         dbSess.IP,
+				LastSeenAt: dbSess.LastSeenAt,
+				Current:    current != nil && dbSess.ID == current.ID,
+			})
+		}
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+func (s server) sessionRevoke() echo.HandlerFunc {
+	type input struct {
+		ID uuid.UUID `param:"ID"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		user, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		var dbSess database.Session
+		if err := s.db.Where("user_id = ?", user.ID).First(&dbSess, i.ID).Error; err != nil {
+			return echo.NotFoundHandler(c)
+		}
+		if err := s.db.Delete(&dbSess).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to revoke session %v, %w", dbSess.ID, err))
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+)
+
+// login tries to log in from the IP address ip and returns the status.
+func (env *testEnv) login(name, password, ip string) int {
+	env.t.Helper()
+	b, err := json.Marshal(map[string]string{"name": name, "password": password})
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/session", bytes.NewReader(b))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderXRealIP, ip)
+	rec := httptest.NewRecorder()
+	env.e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestLoginThrottlePerIP(t *testing.T) {
+	env := newTestEnv(t)
+	env.user("alice")
+	for range loginFreeAttempts + 1 {
+		if got := env.login("alice", "wrong", "192.0.2.1"); got != http.StatusUnauthorized {
+			t.Fatalf("wrong password got %v, want 401", got)
+		}
+	}
+	if got := env.login("alice", "secret", "192.0.2.1"); got != http.StatusTooManyRequests {
+		t.Errorf("throttled ip got %v, want 429", got)
+	}
+	if got := env.login("alice", "secret", "192.0.2.2"); got != http.StatusOK {
+		t.Errorf("other ip got %v, want 200", got)
+	}
+}
+
+func TestUserLockout(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.user("admin")
+	if err := env.db.Model(&database.User{}).Where("name = ?", "admin").Update("is_admin", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	env.user("alice")
+
+	// every attempt from another ip, so only the lockout of the user applies
+	for n := range userLockoutThreshold {
+		if got := env.login("alice", "wrong", fmt.Sprintf("192.0.2.%d", n+1)); got != http.StatusUnauthorized {
+			t.Fatalf("wrong password got %v, want 401", got)
+		}
+	}
+	var u database.User
+	if err := env.db.First(&u, "name = ?", "alice").Error; err != nil {
+		t.Fatal(err)
+	}
+	if u.LockedUntil == nil {
+		t.Fatal("user not locked")
+	}
+	if got := env.login("alice", "secret", "198.51.100.1"); got != http.StatusUnauthorized {
+		t.Errorf("locked user got %v, want 401", got)
+	}
+
+	// only admins unlock users
+	bob := env.user("bob")
+	path := fmt.Sprintf("/api/v1/users/%v/unlock", u.ID)
+	if rec := env.do(http.MethodPost, path, nil, bob); rec.Code != http.StatusForbidden {
+		t.Errorf("unlock by non-admin got %v, want 403", rec.Code)
+	}
+	if rec := env.do(http.MethodPost, path, nil, admin); rec.Code != http.StatusOK {
+		t.Fatalf("unlock got %v, want 200", rec.Code)
+	}
+	if got := env.login("alice", "secret", "198.51.100.1"); got != http.StatusOK {
+		t.Errorf("unlocked user got %v, want 200", got)
+	}
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+	"gorm.io/gorm"
+)
+
+// sessionShareIDs returns the ids of all shares redeemed in the current session.
+func sessionShareIDs(c echo.Context) ([]string, error) {
+	sess, err := session.Get(sessionKey, c)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get session, %w", err)
+	}
+	ids, _ := sess.Values[sharesKey].([]string)
+	return ids, nil
+}
+
+// headerShareToken is the header carrying a share token instead of a session.
+const headerShareToken = "X-Share-Token"
+
+// shareToken returns the share token sent with the request in the
+// X-Share-Token header or the share query parameter, which is meant for
+// EventSource and WebSocket clients, which can not set headers.
+func shareToken(c echo.Context) (string, bool) {
+	if token := c.Request().Header.Get(headerShareToken); token != "" {
+		return token, true
+	}
+	if token := c.QueryParam("share"); token != "" {
+		return token, true
+	}
+	return "", false
+}
+
+// requestShare returns the share with the best role for the list l given by
+// the share token of the request or, without token, redeemed in the current
+// session. It returns nil, if there is none. Revoked and expired shares are
+// ignored.
+func (s server) requestShare(c echo.Context, l database.List) (*database.ListShare, error) {
+	q := s.db.Where("list_id = ?", l.ID)
+	if token, ok := shareToken(c); ok {
+		q = q.Where("token_hash = ?", hashSecret(token))
+	} else {
+		ids, err := sessionShareIDs(c)
+		if err != nil || len(ids) == 0 {
+			return nil, err
+		}
+		q = q.Where("id IN ?", ids)
+	}
+
+	shs := []database.ListShare{}
+	if err := q.Find(&shs).Error; err != nil {
+		return nil, fmt.Errorf("unable to get shares from database, %w", err)
+	}
+
+	var best *database.ListShare
+	now := time.Now()
+	for _, sh := range shs {
+		if sh.Expired(now) {
+			continue
+		}
+		if best == nil || !best.Role.Allows(sh.Role) {
+			best = &sh
+		}
+	}
+	return best, nil
+}
+
+// useShare counts a use of the share sh. It fails with 410, if the share is
+// used up.
+func (s server) useShare(sh *database.ListShare) error {
+	x := s.db.Model(sh).
+		Where("max_uses = 0 OR uses < max_uses").
+		UpdateColumn("uses", gorm.Expr("uses + 1"))
+	if x.Error != nil {
+		return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to count use of share, %w", x.Error))
+	}
+	if x.RowsAffected == 0 {
+		return echo.NewHTTPError(http.StatusGone, "share used up")
+	}
+	return nil
+}
+
+// shareCreated is the response to the creation of a share. This is the only
+// time, the token is available.
+type shareCreated struct {
+	database.ListShare
+	Token string
+}
+
+func (s server) shareList() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		shs := []database.ListShare{}
+		if err := s.db.Where("list_id = ?", a.List.ID).Order("created_at asc").Find(&shs).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get shares from database, %w", err))
+		}
+		return c.JSON(http.StatusOK, shs)
+	}
+}
+
+func (s server) shareCreate() echo.HandlerFunc {
+	type input struct {
+		Role      database.Role `json:"Role"`
+		ExpiresAt *time.Time    `json:"ExpiresAt"`
+		MaxUses   int           `json:"MaxUses"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		if i.Role != database.RoleEditor && i.Role != database.RoleViewer {
+			return echo.ErrBadRequest.SetInternal(fmt.Errorf("invalid role %q", i.Role))
+		}
+		if i.MaxUses < 0 {
+			return echo.ErrBadRequest.SetInternal(errors.New("negative MaxUses"))
+		}
+		if i.ExpiresAt != nil && i.ExpiresAt.Before(time.Now()) {
+			return echo.ErrBadRequest.SetInternal(errors.New("ExpiresAt in the past"))
+		}
+
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		token, hash, err := newSecret()
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		sh := database.ListShare{
+			ListID:    a.List.ID,
+			TokenHash: hash,
+			Role:      i.Role,
+			ExpiresAt: i.ExpiresAt,
+			MaxUses:   i.MaxUses,
+		}
+		if err := s.db.Create(&sh).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusCreated, shareCreated{ListShare: sh, Token: token})
+	}
+}
+
+func (s server) shareDelete() echo.HandlerFunc {
+	type input struct {
+		ShareID uuid.UUID `param:"shareID"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		var sh database.ListShare
+		if err := s.db.Where("list_id = ?", a.List.ID).First(&sh, i.ShareID).Error; err != nil {
+			return echo.NotFoundHandler(c)
+		}
+		if err := s.db.Delete(&sh).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to revoke share %v, %w", sh.ID, err))
+		}
+		return c.JSON(http.StatusOK, sh)
+	}
+}
+
+// shareRedeem counts a use of a share and remembers it in the session, so the
+// entry and list handlers accept it as an alternative to a membership without
+// sending the token with every request.
+func (s server) shareRedeem() echo.HandlerFunc {
+	type input struct {
+		Token string `json:"Token"`
+	}
+	type output struct {
+		ListID uuid.UUID
+		Role   database.Role
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		var sh database.ListShare
+		err := s.db.First(&sh, "token_hash = ?", hashSecret(i.Token)).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return echo.NotFoundHandler(c)
+		}
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get share from database, %w", err))
+		}
+		if sh.Expired(time.Now()) {
+			return echo.NewHTTPError(http.StatusGone, "share expired")
+		}
+
+		ids, err := sessionShareIDs(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		// Redeeming the same share again in the same session is not another use
+		if !slices.Contains(ids, sh.ID.String()) {
+			if err := s.useShare(&sh); err != nil {
+				return err
+			}
+
+			sess, err := session.Get(sessionKey, c)
+			if err != nil {
+				return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get session, %w", err))
+			}
+			if sess.IsNew {
+				sess.Options = newSessionOptions()
+			}
+			sess.Values[sharesKey] = append(ids, sh.ID.String())
+			if err := sess.Save(c.Request(), c.Response()); err != nil {
+				return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to save session, %w", err))
+			}
+		}
+
+		return c.JSON(http.StatusOK, output{ListID: sh.ListID, Role: sh.Role})
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+)
+
+// share creates a share of the list as the owner and returns it.
+func (env *testEnv) share(owner *http.Cookie, listID uuid.UUID, body map[string]any) shareCreated {
+	env.t.Helper()
+	rec := env.do(http.MethodPost, fmt.Sprintf("/api/v1/lists/%v/shares", listID), body, owner)
+	if rec.Code != http.StatusCreated {
+		env.t.Fatalf("creating share failed with %v", rec.Code)
+	}
+	var sh shareCreated
+	env.decode(rec, &sh)
+	return sh
+}
+
+// withShareToken sends a request like do, but with the share token instead of
+// a session.
+func (env *testEnv) withShareToken(method, path, body, token string) int {
+	env.t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(headerShareToken, token)
+	rec := httptest.NewRecorder()
+	env.e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// redeem redeems the share token in the session of the cookie, which may be
+// nil for a new session, and returns the status and the session cookie.
+func (env *testEnv) redeem(token string, cookie *http.Cookie) (int, *http.Cookie) {
+	env.t.Helper()
+	rec := env.do(http.MethodPost, "/api/v1/shares/redeem", map[string]string{"Token": token}, cookie)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionKey {
+			cookie = c
+		}
+	}
+	return rec.Code, cookie
+}
+
+func TestShareToken(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, _ := env.list(owner)
+	sh := env.share(owner, l.ID, map[string]any{"Role": "viewer", "MaxUses": 2})
+
+	listPath := fmt.Sprintf("/api/v1/lists/%v", l.ID)
+	entry := fmt.Sprintf(`{"Name": "bread", "ListID": "%v"}`, l.ID)
+	if got := env.withShareToken(http.MethodGet, listPath, "", sh.Token); got != http.StatusOK {
+		t.Errorf("first use got %v, want 200", got)
+	}
+	// denied requests are no use
+	if got := env.withShareToken(http.MethodPost, "/api/v1/entries", entry, sh.Token); got != http.StatusForbidden {
+		t.Errorf("creating entry as viewer got %v, want 403", got)
+	}
+	if got := env.withShareToken(http.MethodGet, listPath, "", sh.Token); got != http.StatusOK {
+		t.Errorf("second use got %v, want 200", got)
+	}
+	if got := env.withShareToken(http.MethodGet, listPath, "", sh.Token); got != http.StatusGone {
+		t.Errorf("third use got %v, want 410", got)
+	}
+	if got := env.withShareToken(http.MethodGet, listPath, "", "bogus"); got != http.StatusForbidden {
+		t.Errorf("bogus token got %v, want 403", got)
+	}
+	// the share token is no session
+	if got := env.withShareToken(http.MethodGet, "/api/v1/lists", "", sh.Token); got != http.StatusUnauthorized {
+		t.Errorf("listing lists got %v, want 401", got)
+	}
+}
+
+func TestShareExpiry(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, _ := env.list(owner)
+	sh := env.share(owner, l.ID, map[string]any{"Role": "editor", "ExpiresAt": time.Now().Add(time.Hour)})
+
+	code, guest := env.redeem(sh.Token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("redeeming got %v, want 200", code)
+	}
+	listPath := fmt.Sprintf("/api/v1/lists/%v", l.ID)
+	if rec := env.do(http.MethodGet, listPath, nil, guest); rec.Code != http.StatusOK {
+		t.Errorf("guest got %v before expiry, want 200", rec.Code)
+	}
+
+	if err := env.db.Model(&database.ListShare{}).Where("id = ?", sh.ID).Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if rec := env.do(http.MethodGet, listPath, nil, guest); rec.Code != http.StatusForbidden {
+		t.Errorf("guest got %v after expiry, want 403", rec.Code)
+	}
+	if got := env.withShareToken(http.MethodGet, listPath, "", sh.Token); got != http.StatusForbidden {
+		t.Errorf("token got %v after expiry, want 403", got)
+	}
+	if code, _ := env.redeem(sh.Token, nil); code != http.StatusGone {
+		t.Errorf("redeeming got %v after expiry, want 410", code)
+	}
+}
+
+func TestShareMaxUses(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, _ := env.list(owner)
+	sh := env.share(owner, l.ID, map[string]any{"Role": "viewer", "MaxUses": 1})
+
+	code, guest := env.redeem(sh.Token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("redeeming got %v, want 200", code)
+	}
+	// redeeming again in the same session is no use
+	if code, _ := env.redeem(sh.Token, guest); code != http.StatusOK {
+		t.Errorf("redeeming again got %v, want 200", code)
+	}
+	if code, _ := env.redeem(sh.Token, nil); code != http.StatusGone {
+		t.Errorf("redeeming in another session got %v, want 410", code)
+	}
+	// the session keeps the access
+	if rec := env.do(http.MethodGet, fmt.Sprintf("/api/v1/lists/%v", l.ID), nil, guest); rec.Code != http.StatusOK {
+		t.Errorf("guest got %v, want 200", rec.Code)
+	}
+}
+
+func TestShareRevoke(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, _ := env.list(owner)
+	sh := env.share(owner, l.ID, map[string]any{"Role": "editor"})
+	code, guest := env.redeem(sh.Token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("redeeming got %v, want 200", code)
+	}
+
+	entry := map[string]any{"Name": "bread", "ListID": l.ID}
+	if rec := env.do(http.MethodPost, "/api/v1/entries", entry, guest); rec.Code != http.StatusCreated {
+		t.Errorf("guest got %v before revocation, want 201", rec.Code)
+	}
+
+	if rec := env.do(http.MethodDelete, fmt.Sprintf("/api/v1/lists/%v/shares/%v", l.ID, sh.ID), nil, owner); rec.Code != http.StatusOK {
+		t.Fatalf("revoking got %v, want 200", rec.Code)
+	}
+	if rec := env.do(http.MethodPost, "/api/v1/entries", entry, guest); rec.Code != http.StatusForbidden {
+		t.Errorf("guest got %v after revocation, want 403", rec.Code)
+	}
+	if got := env.withShareToken(http.MethodGet, fmt.Sprintf("/api/v1/lists/%v", l.ID), "", sh.Token); got != http.StatusForbidden {
+		t.Errorf("token got %v after revocation, want 403", got)
+	}
+	if code, _ := env.redeem(sh.Token, nil); code != http.StatusNotFound {
+		t.Errorf("redeeming got %v after revocation, want 404", code)
+	}
+}
+
+func TestShareRevokeEndsStream(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, _ := env.list(owner)
+	sh := env.share(owner, l.ID, map[string]any{"Role": "viewer"})
+
+	srv := httptest.NewServer(env.e)
+	defer srv.Close()
+	resp, err := http.Get(fmt.Sprintf("%v/api/v1/entries/events?ListID=%v&share=%v", srv.URL, l.ID, sh.Token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream got %v, want 200", resp.StatusCode)
+	}
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	// the presence snapshot tells that the stream is running
+	for line := range lines {
+		if line == "event: presence.snapshot" {
+			break
+		}
+	}
+
+	if rec := env.do(http.MethodDelete, fmt.Sprintf("/api/v1/lists/%v/shares/%v", l.ID, sh.ID), nil, owner); rec.Code != http.StatusOK {
+		t.Fatalf("revoking got %v, want 200", rec.Code)
+	}
+	if rec := env.do(http.MethodPost, "/api/v1/entries", map[string]any{"Name": "bread", "ListID": l.ID}, owner); rec.Code != http.StatusCreated {
+		t.Fatalf("creating entry got %v, want 201", rec.Code)
+	}
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if strings.HasPrefix(line, "event: entry.") {
+				t.Fatalf("got %q after revocation", line)
+			}
+		case <-timeout:
+			t.Fatal("stream still open after revocation")
+		}
+	}
+}
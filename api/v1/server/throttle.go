@@ -0,0 +1,132 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// loginFreeAttempts is the number of failed logins from an IP address
+	// before it has to wait between attempts.
+	loginFreeAttempts = 5
+	// loginBaseDelay is the wait time after the first attempt exceeding
+	// loginFreeAttempts. It doubles with every further failed attempt.
+	loginBaseDelay = time.Second
+	// loginMaxDelay caps the wait time.
+	loginMaxDelay = 15 * time.Minute
+	// loginForgetAfter is the time after which failed attempts are forgotten.
+	loginForgetAfter = time.Hour
+
+	// userLockoutThreshold is the number of failed logins after which a user is locked.
+	userLockoutThreshold = 10
+	// userLockoutBase is the duration of the first lockout of a user. It
+	// doubles with every further failed login.
+	userLockoutBase = 15 * time.Minute
+	// userLockoutMax caps the duration of a lockout.
+	userLockoutMax = 24 * time.Hour
+)
+
+// backoff returns base doubled n times, but at most max.
+func backoff(base, max time.Duration, n int) time.Duration {
+	d := base
+	for range n {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+	return d
+}
+
+type loginAttempts struct {
+	failures int
+	// users are the failures per user name
+	users        map[string]int
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// loginThrottle tracks failed logins per IP address in memory and slows down
+// clients with exponential backoff.
+type loginThrottle struct {
+	m         sync.Mutex
+	attempts  map[string]*loginAttempts
+	lastPrune time.Time
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{
+		attempts: map[string]*loginAttempts{},
+	}
+}
+
+// wait returns how long the ip has to wait before the next attempt.
+func (t *loginThrottle) wait(ip string) time.Duration {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	a, ok := t.attempts[ip]
+	if !ok {
+		return 0
+	}
+	return max(time.Until(a.blockedUntil), 0)
+}
+
+// fail records a failed login from ip as the user with the name user.
+func (t *loginThrottle) fail(ip, user string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	now := time.Now()
+	t.prune(now)
+
+	a, ok := t.attempts[ip]
+	if !ok {
+		a = &loginAttempts{users: map[string]int{}}
+		t.attempts[ip] = a
+	}
+	a.failures++
+	a.users[user]++
+	a.last = now
+	if a.failures > loginFreeAttempts {
+		a.blockedUntil = now.Add(backoff(loginBaseDelay, loginMaxDelay, a.failures-loginFreeAttempts-1))
+	}
+}
+
+// succeed forgets the failed logins from ip as the user with the name user
+// after a successful login of this user, e.g. after mistyping the password.
+// The failed logins as other users are kept, otherwise logging into an own
+// account between attempts would reset the backoff for guessing the
+// passwords of others.
+func (t *loginThrottle) succeed(ip, user string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	a, ok := t.attempts[ip]
+	if !ok {
+		return
+	}
+	a.failures -= a.users[user]
+	delete(a.users, user)
+	if a.failures == 0 {
+		delete(t.attempts, ip)
+		return
+	}
+	if a.failures <= loginFreeAttempts {
+		a.blockedUntil = time.Time{}
+	}
+}
+
+// prune removes attempts, which are old enough to be forgotten.
+// This is for internal use and has therefor the Mutex not locked, so use with care.
+func (t *loginThrottle) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < time.Minute {
+		return
+	}
+	t.lastPrune = now
+	for ip, a := range t.attempts {
+		if now.Sub(a.last) > loginForgetAfter {
+			delete(t.attempts, ip)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import "testing"
+
+func TestLoginThrottleSucceed(t *testing.T) {
+	lt := newLoginThrottle()
+	for range loginFreeAttempts + 1 {
+		lt.fail("192.0.2.1", "alice")
+	}
+	lt.fail("192.0.2.2", "alice")
+	if lt.wait("192.0.2.1") == 0 {
+		t.Fatal("ip not throttled after too many failed logins")
+	}
+
+	lt.succeed("192.0.2.1", "alice")
+	if wait := lt.wait("192.0.2.1"); wait != 0 {
+		t.Errorf("ip still throttled for %v after successful login", wait)
+	}
+	// the failures start from scratch
+	for range loginFreeAttempts {
+		lt.fail("192.0.2.1", "alice")
+	}
+	if wait := lt.wait("192.0.2.1"); wait != 0 {
+		t.Errorf("ip throttled for %v within the free attempts", wait)
+	}
+	if _, ok := lt.attempts["192.0.2.2"]; !ok {
+		t.Error("failures of other ip forgotten")
+	}
+}
+
+func TestLoginThrottleSucceedOtherUser(t *testing.T) {
+	lt := newLoginThrottle()
+	// guessing the passwords of others and logging into an own account in
+	// between does not reset the backoff
+	for range loginFreeAttempts + 1 {
+		lt.fail("192.0.2.1", "bob")
+	}
+	lt.fail("192.0.2.1", "mallory")
+	lt.succeed("192.0.2.1", "mallory")
+	if lt.wait("192.0.2.1") == 0 {
+		t.Error("ip not throttled after successful login as another user")
+	}
+	if a := lt.attempts["192.0.2.1"]; a.failures != loginFreeAttempts+1 {
+		t.Errorf("%v failures recorded, want %v", a.failures, loginFreeAttempts+1)
+	}
+}
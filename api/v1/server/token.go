@@ -0,0 +1,163 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+)
+
+const apiTokenKey = "apiToken"
+
+// bearerToken returns the token from the Authorization header, if there is one.
+func bearerToken(c echo.Context) (string, bool) {
+	return strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+}
+
+// tokenMiddleware authenticates the request with the bearer token and
+// ensures the token has the scope needed for the request method.
+func (s server) tokenMiddleware(token string, next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var t database.APIToken
+		if err := s.db.Preload("User").First(&t, "token_hash = ?", hashSecret(token)).Error; err != nil {
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("unable to get token from database, %w", err))
+		}
+		now := time.Now()
+		if t.ExpiresAt != nil && !now.Before(*t.ExpiresAt) {
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("token %v expired", t.ID))
+		}
+
+		u := t.User
+		if u.ID == uuid.Nil {
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("user of token %v not found", t.ID))
+		}
+		if u.Disabled {
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("user %v is disabled", u.Name))
+		}
+
+		scope := database.ScopeWrite
+		switch c.Request().Method {
+		case http.MethodGet, http.MethodHead:
+			scope = database.ScopeRead
+		}
+		if !slices.Contains(t.Scopes, scope) {
+			return echo.ErrForbidden.SetInternal(fmt.Errorf("token %v is missing scope %v", t.ID, scope))
+		}
+
+		// Only update the token from time to time to spare the database
+		if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) > time.Minute {
+			t.LastUsedAt = &now
+			if err := s.db.Model(&t).UpdateColumn("last_used_at", now).Error; err != nil {
+				return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to update token, %w", err))
+			}
+		}
+
+		c.Set(userKey, &u)
+		c.Set(apiTokenKey, &t)
+		return next(c)
+	}
+}
+
+// apiTokenCreated is the response to the creation of a token. This is the
+// only time, the token is available.
+type apiTokenCreated struct {
+	database.APIToken
+	Token string
+}
+
+func (s server) tokenList() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		ts := []database.APIToken{}
+		if err := s.db.Where("user_id = ?", user.ID).Order("created_at asc").Find(&ts).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get tokens from database, %w", err))
+		}
+		return c.JSON(http.StatusOK, ts)
+	}
+}
+
+func (s server) tokenCreate() echo.HandlerFunc {
+	type input struct {
+		Name      string     `json:"Name"`
+		Scopes    []string   `json:"Scopes"`
+		ExpiresAt *time.Time `json:"ExpiresAt"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		if i.Name == "" {
+			return echo.ErrBadRequest.SetInternal(errors.New("missing name"))
+		}
+		if len(i.Scopes) == 0 {
+			return echo.ErrBadRequest.SetInternal(errors.New("missing scopes"))
+		}
+		for _, scope := range i.Scopes {
+			if scope != database.ScopeRead && scope != database.ScopeWrite {
+				return echo.ErrBadRequest.SetInternal(fmt.Errorf("invalid scope %q", scope))
+			}
+		}
+		if i.ExpiresAt != nil && i.ExpiresAt.Before(time.Now()) {
+			return echo.ErrBadRequest.SetInternal(errors.New("ExpiresAt in the past"))
+		}
+
+		user, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		token, hash, err := newSecret()
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		t := database.APIToken{
+			UserID:    user.ID,
+			Name:      i.Name,
+			TokenHash: hash,
+			Scopes:    slices.Compact(slices.Sorted(slices.Values(i.Scopes))),
+			ExpiresAt: i.ExpiresAt,
+		}
+		if err := s.db.Omit("User").Create(&t).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusCreated, apiTokenCreated{APIToken: t, Token: token})
+	}
+}
+
+func (s server) tokenDelete() echo.HandlerFunc {
+	type input struct {
+		ID uuid.UUID `param:"ID"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		user, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		var t database.APIToken
+		if err := s.db.Where("user_id = ?", user.ID).First(&t, i.ID).Error; err != nil {
+			return echo.NotFoundHandler(c)
+		}
+		if err := s.db.Delete(&t).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to revoke token %v, %w", t.ID, err))
+		}
+		return c.JSON(http.StatusOK, t)
+	}
+}
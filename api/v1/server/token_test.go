@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+)
+
+func TestTokenRoutes(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.user("admin")
+	if err := env.db.Model(&database.User{}).Where("name = ?", "admin").Update("is_admin", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	l, _ := env.list(admin)
+
+	rec := env.do(http.MethodPost, "/api/v1/tokens", map[string]any{"Name": "automation", "Scopes": []string{"read", "write"}}, admin)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating token failed with %v", rec.Code)
+	}
+	var created apiTokenCreated
+	env.decode(rec, &created)
+
+	withToken := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+created.Token)
+		// the token does not grant access, even with a session
+		req.AddCookie(admin)
+		rec := httptest.NewRecorder()
+		env.e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	allowed := []struct{ method, path, body string }{
+		{http.MethodGet, "/api/v1/lists", ""},
+		{http.MethodGet, "/api/v1/lists/" + l.ID.String(), ""},
+		{http.MethodPost, "/api/v1/entries", `{"Name": "milk", "ListID": "` + l.ID.String() + `"}`},
+	}
+	for _, r := range allowed {
+		if got := withToken(r.method, r.path, r.body); got != http.StatusOK && got != http.StatusCreated {
+			t.Errorf("%v %v with token got %v", r.method, r.path, got)
+		}
+	}
+
+	denied := []struct{ method, path, body string }{
+		{http.MethodGet, "/api/v1/tokens", ""},
+		{http.MethodPost, "/api/v1/tokens", `{"Name": "more", "Scopes": ["write"]}`},
+		{http.MethodGet, "/api/v1/session", ""},
+		{http.MethodGet, "/api/v1/session/all", ""},
+		{http.MethodPost, "/api/v1/session/totp", ""},
+		{http.MethodDelete, "/api/v1/session/totp", `{"Code": "000000"}`},
+		{http.MethodGet, "/api/v1/users", ""},
+		{http.MethodPost, "/api/v1/invitations", "{}"},
+		{http.MethodGet, "/api/v1/admin/realtime", ""},
+	}
+	for _, r := range denied {
+		if got := withToken(r.method, r.path, r.body); got != http.StatusUnauthorized {
+			t.Errorf("%v %v with token got %v, want 401", r.method, r.path, got)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+	"github.com/shaardie/listinator/totp"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer = "Listinator"
+
+	// pendingUserKey and pendingUntilKey store the user, which logged in with
+	// the password, but still needs to provide the second factor.
+	pendingUserKey  = "pendingUser"
+	pendingUntilKey = "pendingUntil"
+	// pendingTimeout is the time a user has to provide the second factor.
+	pendingTimeout = 5 * time.Minute
+
+	recoveryCodeCount = 10
+)
+
+// startPendingSession remembers the user u in the session cookie until the
+// second factor is provided.
+func (s server) startPendingSession(c echo.Context, u database.User) error {
+	sess, err := session.Get(sessionKey, c)
+	if err != nil {
+		return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get session, %w", err))
+	}
+	sess.Options = newSessionOptions()
+	sess.Values[pendingUserKey] = u.ID.String()
+	sess.Values[pendingUntilKey] = time.Now().Add(pendingTimeout).Unix()
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to save session, %w", err))
+	}
+	return c.JSON(http.StatusAccepted, map[string]bool{"TOTPRequired": true})
+}
+
+// checkSecondFactor checks either the TOTP code or the recovery code of the
+// user u. Used recovery codes are deleted.
+func (s server) checkSecondFactor(u *database.User, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := totp.Validate(u.TOTPSecret, code, time.Now(), u.TOTPLastStep)
+		if !ok {
+			return false, nil
+		}
+		// Only one of parallel logins with the same code may use it
+		res := s.db.Model(&database.User{}).
+			Where("id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)", u.ID, step).
+			UpdateColumn("totp_last_step", step)
+		if res.Error != nil {
+			return false, fmt.Errorf("unable to update last TOTP step, %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return false, nil
+		}
+		u.TOTPLastStep = step
+		return true, nil
+	}
+
+	if recoveryCode == "" {
+		return false, nil
+	}
+	recoveryCode = normalizeRecoveryCode(recoveryCode)
+	rcs := []database.RecoveryCode{}
+	if err := s.db.Where("user_id = ?", u.ID).Find(&rcs).Error; err != nil {
+		return false, fmt.Errorf("unable to get recovery codes from database, %w", err)
+	}
+	for _, rc := range rcs {
+		if database.CompareHashAndPassword(rc.CodeHash, recoveryCode) != nil {
+			continue
+		}
+		res := s.db.Unscoped().Delete(&rc)
+		if res.Error != nil {
+			return false, fmt.Errorf("unable to use recovery code, %w", res.Error)
+		}
+		// The code was used by a parallel login
+		if res.RowsAffected == 0 {
+			return false, nil
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// newRecoveryCodes replaces all recovery codes of the user u with new ones
+// and returns them.
+func newRecoveryCodes(tx *gorm.DB, u database.User) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", u.ID).Delete(&database.RecoveryCode{}).Error; err != nil {
+		return nil, fmt.Errorf("unable to delete old recovery codes, %w", err)
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("unable to read random bytes, %w", err)
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		hash, err := database.HashPassword(code)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Create(&database.RecoveryCode{UserID: u.ID, CodeHash: hash}).Error; err != nil {
+			return nil, fmt.Errorf("unable to create recovery code, %w", err)
+		}
+		codes = append(codes, code[:4]+"-"+code[4:])
+	}
+	return codes, nil
+}
+
+// sessionVerify is the second step of the login for users with TOTP enabled.
+func (s server) sessionVerify() echo.HandlerFunc {
+	type input struct {
+		Code         string `json:"Code"`
+		RecoveryCode string `json:"RecoveryCode"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		ip := c.RealIP()
+		if wait := s.loginThrottle.wait(ip); wait > 0 {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			return echo.ErrTooManyRequests.SetInternal(fmt.Errorf("too many failed logins from %v", ip))
+		}
+
+		sess, err := session.Get(sessionKey, c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get session, %w", err))
+		}
+		idStr, _ := sess.Values[pendingUserKey].(string)
+		until, _ := sess.Values[pendingUntilKey].(int64)
+		if idStr == "" || time.Now().Unix() > until {
+			return echo.ErrUnauthorized.SetInternal(errors.New("no pending login"))
+		}
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("unable to parse pending user id, %w", err))
+		}
+
+		var u database.User
+		if err := s.db.First(&u, id).Error; err != nil {
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("unable to get user from database, %w", err))
+		}
+		if u.Disabled || u.Locked(time.Now()) || !u.TOTPEnabled {
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("user %v is disabled, locked or has no TOTP", u.Name))
+		}
+
+		ok, err := s.checkSecondFactor(&u, i.Code, i.RecoveryCode)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		if !ok {
+			s.loginThrottle.fail(ip, u.Name)
+			if err := s.recordFailedLogin(u); err != nil {
+				return echo.ErrInternalServerError.SetInternal(err)
+			}
+			return echo.ErrUnauthorized.SetInternal(fmt.Errorf("wrong second factor for user %v", u.Name))
+		}
+
+		return s.completeLogin(c, u)
+	}
+}
+
+func (s server) totpEnroll() echo.HandlerFunc {
+	type output struct {
+		Secret string
+		URI    string
+	}
+	return func(c echo.Context) error {
+		user, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		if user.TOTPEnabled {
+			return echo.NewHTTPError(http.StatusConflict, "TOTP already enabled")
+		}
+
+		secret, err := totp.NewSecret()
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		if err := s.db.Model(user).UpdateColumn("totp_secret", secret).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to store TOTP secret, %w", err))
+		}
+		return c.JSON(http.StatusOK, output{
+			Secret: secret,
+			URI:    totp.URI(totpIssuer, user.Name, secret),
+		})
+	}
+}
+
+func (s server) totpConfirm() echo.HandlerFunc {
+	type input struct {
+		Code string `json:"Code"`
+	}
+	type output struct {
+		RecoveryCodes []string
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		user, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		if user.TOTPEnabled {
+			return echo.NewHTTPError(http.StatusConflict, "TOTP already enabled")
+		}
+		if user.TOTPSecret == "" {
+			return echo.ErrBadRequest.SetInternal(errors.New("TOTP enrollment not started"))
+		}
+
+		step, ok := totp.Validate(user.TOTPSecret, i.Code, time.Now(), 0)
+		if !ok {
+			return echo.ErrBadRequest.SetInternal(errors.New("wrong TOTP code"))
+		}
+
+		var codes []string
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(user).Select("totp_enabled", "totp_last_step").Updates(map[string]any{"totp_enabled": true, "totp_last_step": step}).Error; err != nil {
+				return fmt.Errorf("unable to enable TOTP, %w", err)
+			}
+			codes, err = newRecoveryCodes(tx, *user)
+			return err
+		})
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusOK, output{RecoveryCodes: codes})
+	}
+}
+
+func (s server) totpDisable() echo.HandlerFunc {
+	type input struct {
+		Code         string `json:"Code"`
+		RecoveryCode string `json:"RecoveryCode"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		user, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		if !user.TOTPEnabled {
+			return echo.ErrBadRequest.SetInternal(errors.New("TOTP not enabled"))
+		}
+
+		ok, err := s.checkSecondFactor(user, i.Code, i.RecoveryCode)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		if !ok {
+			return echo.ErrForbidden.SetInternal(errors.New("wrong second factor"))
+		}
+
+		if err := resetTOTP(s.db, *user); err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// resetTOTP disables TOTP for the user u and deletes the recovery codes.
+func resetTOTP(db *gorm.DB, u database.User) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&u).Select("totp_secret", "totp_enabled", "totp_last_step").Updates(map[string]any{"totp_secret": "", "totp_enabled": false, "totp_last_step": 0}).Error; err != nil {
+			return fmt.Errorf("unable to disable TOTP, %w", err)
+		}
+		if err := tx.Unscoped().Where("user_id = ?", u.ID).Delete(&database.RecoveryCode{}).Error; err != nil {
+			return fmt.Errorf("unable to delete recovery codes, %w", err)
+		}
+		return nil
+	})
+}
+
+func (s server) userTOTPReset() echo.HandlerFunc {
+	type input struct {
+		ID uuid.UUID `param:"ID"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		var u database.User
+		if err := s.db.First(&u, i.ID).Error; err != nil {
+			return echo.NotFoundHandler(c)
+		}
+		if err := resetTOTP(s.db, u); err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to reset TOTP of user %v, %w", u.ID, err))
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
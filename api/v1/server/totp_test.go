@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shaardie/listinator/database"
+	"github.com/shaardie/listinator/totp"
+)
+
+func TestCheckSecondFactorReplay(t *testing.T) {
+	env := newTestEnv(t)
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := database.User{Name: "alice", TOTPSecret: secret, TOTPEnabled: true}
+	if err := env.db.Create(&u).Error; err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two parallel logins read the user before either used the code
+	first, second := u, u
+	if ok, err := env.s.checkSecondFactor(&first, code, ""); err != nil || !ok {
+		t.Fatalf("first use of the code failed, %v, %v", ok, err)
+	}
+	if ok, err := env.s.checkSecondFactor(&second, code, ""); err != nil || ok {
+		t.Errorf("second use of the code got %v, %v, want rejected", ok, err)
+	}
+}
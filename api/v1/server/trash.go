@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+	"gorm.io/gorm"
+)
+
+// trashPurgeInterval is the interval in which deleted entries older than the
+// retention are purged.
+const trashPurgeInterval = time.Hour
+
+// listTrash returns the deleted entries of the list, which can still be
+// restored, the most recently deleted first.
+func (s server) listTrash() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		q := s.db.Unscoped().Where("list_id = ? AND deleted_at IS NOT NULL", a.List.ID)
+		if s.cfg.TrashRetention > 0 {
+			q = q.Where("deleted_at > ?", time.Now().Add(-s.cfg.TrashRetention))
+		}
+		es := []database.Entry{}
+		if err := q.Order("deleted_at desc").Find(&es).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get entries from database, %w", err))
+		}
+		return c.JSON(http.StatusOK, es)
+	}
+}
+
+// entryRestore undeletes an entry from the trash.
+func (s server) entryRestore() echo.HandlerFunc {
+	type input struct {
+		ID uuid.UUID `param:"ID"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		var e database.Entry
+		if err := s.db.Unscoped().First(&e, i.ID).Error; err != nil {
+			return echo.NotFoundHandler(c)
+		}
+		if !e.DeletedAt.Valid {
+			return echo.NewHTTPError(http.StatusConflict, "entry is not deleted")
+		}
+
+		_, err := s.transact(func(tx *gorm.DB) ([]event, error) {
+			var evs []event
+			var err error
+			e, evs, err = restoreEntry(c, tx, e)
+			return evs, err
+		})
+		if errors.Is(err, errVersionConflict) {
+			return echo.NewHTTPError(http.StatusConflict, "entry was restored or changed in the meantime")
+		}
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		c.Response().Header().Set("ETag", entryETag(e))
+		return c.JSON(http.StatusOK, e)
+	}
+}
+
+// purgeTrash deletes the entries, which are deleted for longer than the
+// retention, for good.
+func (s server) purgeTrash() (int64, error) {
+	res := s.db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", time.Now().Add(-s.cfg.TrashRetention)).
+		Delete(&database.Entry{})
+	if res.Error != nil {
+		return 0, fmt.Errorf("unable to purge deleted entries, %w", res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+// runTrashPurge purges the trash periodically until the server is shut down.
+func (s server) runTrashPurge() {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		n, err := s.purgeTrash()
+		if err != nil {
+			slog.Error("unable to purge trash", "error", err)
+		} else if n > 0 {
+			slog.Info("purged trash", "entries", n)
+		}
+
+		select {
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
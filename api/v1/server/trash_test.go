@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shaardie/listinator/database"
+)
+
+// trash returns the names of the entries in the trash of the list.
+func (env *testEnv) trash(l database.List, cookie *http.Cookie) []string {
+	env.t.Helper()
+	rec := env.do(http.MethodGet, fmt.Sprintf("/api/v1/lists/%v/trash", l.ID), nil, cookie)
+	if rec.Code != http.StatusOK {
+		env.t.Fatalf("getting trash failed with %v", rec.Code)
+	}
+	var es []database.Entry
+	env.decode(rec, &es)
+	names := make([]string, 0, len(es))
+	for _, e := range es {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+// deletedList creates a list with eggs, and bread and milk, which are
+// deleted in this order.
+func (env *testEnv) deletedList(cookie *http.Cookie) (database.List, map[string]database.Entry) {
+	env.t.Helper()
+	l, milk := env.list(cookie)
+	es := map[string]database.Entry{"milk": milk}
+	for _, name := range []string{"bread", "eggs"} {
+		rec := env.do(http.MethodPost, "/api/v1/entries", map[string]any{"Name": name, "ListID": l.ID}, cookie)
+		if rec.Code != http.StatusCreated {
+			env.t.Fatalf("creating %v failed with %v", name, rec.Code)
+		}
+		var e database.Entry
+		env.decode(rec, &e)
+		es[name] = e
+	}
+	for _, name := range []string{"bread", "milk"} {
+		if rec := env.do(http.MethodDelete, fmt.Sprintf("/api/v1/entries/%v", es[name].ID), nil, cookie); rec.Code != http.StatusOK {
+			env.t.Fatalf("deleting %v failed with %v", name, rec.Code)
+		}
+		// distinct deletion times
+		time.Sleep(10 * time.Millisecond)
+	}
+	return l, es
+}
+
+func TestTrash(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, es := env.deletedList(owner)
+
+	// the most recently deleted first
+	if got := fmt.Sprint(env.trash(l, owner)); got != "[milk bread]" {
+		t.Errorf("got trash %v, want [milk bread]", got)
+	}
+	other := env.user("other")
+	if rec := env.do(http.MethodGet, fmt.Sprintf("/api/v1/lists/%v/trash", l.ID), nil, other); rec.Code != http.StatusForbidden {
+		t.Errorf("trash of foreign list got %v, want 403", rec.Code)
+	}
+
+	id, ch, err := env.s.eventPubSub.Subscribe(l.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.s.eventPubSub.Unsubscribe(l.ID, id)
+
+	restore := fmt.Sprintf("/api/v1/entries/%v/restore", es["bread"].ID)
+	rec := env.do(http.MethodPost, restore, nil, owner)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("restore got %v, want 200", rec.Code)
+	}
+	var restored database.Entry
+	env.decode(rec, &restored)
+	if restored.ID != es["bread"].ID || restored.DeletedAt.Valid {
+		t.Errorf("got %+v, want restored bread", restored)
+	}
+	if names := env.entryNames(l); len(names) != 2 {
+		t.Errorf("list contains %v, want bread and eggs", names)
+	}
+	if got := fmt.Sprint(env.trash(l, owner)); got != "[milk]" {
+		t.Errorf("got trash %v after restore, want [milk]", got)
+	}
+
+	// the subscribers get the entry back
+	select {
+	case ev := <-ch:
+		if ev.Type != eventEntryCreated {
+			t.Fatalf("got event %v, want %v", ev.Type, eventEntryCreated)
+		}
+		var p entryPayload
+		if err := json.Unmarshal(ev.Payload, &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.ID != restored.ID || p.Name != "bread" || p.Version != restored.Version {
+			t.Errorf("got payload %+v, want restored bread", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+
+	tests := []struct {
+		name string
+		id   any
+		want int
+	}{
+		{"restored entry", es["bread"].ID, http.StatusConflict},
+		{"entry not deleted", es["eggs"].ID, http.StatusConflict},
+		{"unknown entry", l.ID, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := env.do(http.MethodPost, fmt.Sprintf("/api/v1/entries/%v/restore", tt.id), nil, owner); rec.Code != tt.want {
+				t.Errorf("got %v, want %v", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrashRetention(t *testing.T) {
+	env := newTestEnvWithConfig(t, Config{SessionIdleTimeout: time.Hour, TrashRetention: time.Hour})
+	owner := env.user("owner")
+	l, es := env.deletedList(owner)
+	if err := env.db.Unscoped().Model(&database.Entry{}).Where("id = ?", es["bread"].ID).Update("deleted_at", time.Now().Add(-2*time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// expired entries are not listed any more
+	if got := fmt.Sprint(env.trash(l, owner)); got != "[milk]" {
+		t.Errorf("got trash %v, want [milk]", got)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	_, es := env.deletedList(owner)
+	if err := env.db.Unscoped().Model(&database.Entry{}).Where("id = ?", es["bread"].ID).Update("deleted_at", time.Now().Add(-2*time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	env.s.cfg.TrashRetention = time.Hour
+	n, err := env.s.purgeTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("purged %v entries, want 1", n)
+	}
+
+	var remaining []database.Entry
+	if err := env.db.Unscoped().Find(&remaining).Error; err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, e := range remaining {
+		names[e.Name] = e.DeletedAt.Valid
+	}
+	if _, ok := names["bread"]; ok || len(names) != 2 || !names["milk"] || names["eggs"] {
+		t.Errorf("got %v after purge, want deleted milk and eggs", names)
+	}
+}
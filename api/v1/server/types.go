@@ -3,9 +3,12 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/shaardie/listinator/database"
+	"gorm.io/gorm"
 )
 
 func (s server) typeList() echo.HandlerFunc {
@@ -17,3 +20,60 @@ func (s server) typeList() echo.HandlerFunc {
 		return c.JSON(http.StatusOK, ts)
 	}
 }
+
+// typeUpdate changes a type. Types are shared by all lists, the active lists
+// with entries of the type are informed about the change. Immutable types can
+// not be changed.
+func (s server) typeUpdate() echo.HandlerFunc {
+	type input struct {
+		ID       uuid.UUID `param:"ID"`
+		Name     string    `json:"Name"`
+		Color    string    `json:"Color"`
+		Priority int       `json:"Priority"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+		if strings.TrimSpace(i.Name) == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "missing Name")
+		}
+
+		var t database.Type
+		if err := s.db.First(&t, i.ID).Error; err != nil {
+			return echo.NotFoundHandler(c)
+		}
+		if t.Immutable {
+			return echo.NewHTTPError(http.StatusForbidden, "type is immutable")
+		}
+		t.Name = i.Name
+		t.Color = i.Color
+		t.Priority = i.Priority
+
+		_, err := s.transact(func(tx *gorm.DB) ([]event, error) {
+			if err := tx.Save(&t).Error; err != nil {
+				return nil, err
+			}
+			var listIDs []uuid.UUID
+			entries := tx.Model(&database.Entry{}).Select("list_id").Where("type_id = ?", t.ID)
+			if err := tx.Model(&database.List{}).Where("archived = ? AND id IN (?)", false, entries).Pluck("id", &listIDs).Error; err != nil {
+				return nil, fmt.Errorf("unable to get lists from database, %w", err)
+			}
+			payload := typePayload{ID: t.ID, Name: t.Name, Color: t.Color, Priority: t.Priority}
+			events := make([]event, 0, len(listIDs))
+			for _, id := range listIDs {
+				ev, err := newEvent(eventTypeChanged, id, actorID(c), payload)
+				if err != nil {
+					return nil, err
+				}
+				events = append(events, ev)
+			}
+			return events, nil
+		})
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusOK, t)
+	}
+}
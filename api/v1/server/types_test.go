@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/shaardie/listinator/database"
+)
+
+func TestTypeUpdate(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.user("admin")
+	if err := env.db.Model(&database.User{}).Where("name = ?", "admin").Update("is_admin", true).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	update := map[string]any{"Name": "Other", "Color": "gray"}
+	// Miscellaneous is immutable
+	if rec := env.do(http.MethodPut, "/api/v1/types/c29ebd85-812e-4cf6-bfc7-c8368eb83334", update, admin); rec.Code != http.StatusForbidden {
+		t.Errorf("immutable type got %v, want 403", rec.Code)
+	}
+	// Fruit & Vegetables & Nuts is not
+	if rec := env.do(http.MethodPut, "/api/v1/types/fe0b085b-2df9-4422-a7cb-7867947719a5", update, admin); rec.Code != http.StatusOK {
+		t.Errorf("mutable type got %v, want 200", rec.Code)
+	}
+
+	var ts []database.Type
+	if err := env.db.Order("priority asc").Find(&ts).Error; err != nil {
+		t.Fatal(err)
+	}
+	if ts[0].Name != "📦 Miscellaneous" || !ts[0].Immutable {
+		t.Errorf("immutable type changed to %+v", ts[0])
+	}
+	if ts[1].Name != "Other" {
+		t.Errorf("mutable type not changed, got %+v", ts[1])
+	}
+}
+
+func TestTypeUpdateEvents(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.user("admin")
+	if err := env.db.Model(&database.User{}).Where("name = ?", "admin").Update("is_admin", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	const fruit = "fe0b085b-2df9-4422-a7cb-7867947719a5"
+
+	lists := map[string]database.List{}
+	for _, name := range []string{"with type", "without type", "archived", "deleted entry"} {
+		l, e := env.list(admin)
+		lists[name] = l
+		if name == "without type" {
+			continue
+		}
+		if rec := env.patch(e, `{"TypeID":"`+fruit+`"}`, admin); rec.Code != http.StatusOK {
+			t.Fatalf("changing type failed with %v", rec.Code)
+		}
+		switch name {
+		case "archived":
+			rec := env.do(http.MethodPut, "/api/v1/lists/"+l.ID.String(), map[string]any{"Name": l.Name, "Archived": true}, admin)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("archiving list failed with %v", rec.Code)
+			}
+		case "deleted entry":
+			if rec := env.do(http.MethodDelete, "/api/v1/entries/"+e.ID.String(), nil, admin); rec.Code != http.StatusOK {
+				t.Fatalf("deleting entry failed with %v", rec.Code)
+			}
+		}
+	}
+
+	if rec := env.do(http.MethodPut, "/api/v1/types/"+fruit, map[string]any{"Name": "Fruit", "Color": "green"}, admin); rec.Code != http.StatusOK {
+		t.Fatalf("updating type failed with %v", rec.Code)
+	}
+	for name, l := range lists {
+		log, err := env.s.eventsAfter(l.ID, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := log[len(log)-1].Type == eventTypeChanged
+		if want := name == "with type"; got != want {
+			t.Errorf("list %v got type.changed %v, want %v", name, got, want)
+		}
+	}
+}
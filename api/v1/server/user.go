@@ -0,0 +1,229 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+	"gorm.io/gorm"
+)
+
+// errUserNameTaken is returned if the unique constraint on the user name is violated.
+func errUserNameTaken(err error) *echo.HTTPError {
+	return echo.NewHTTPError(http.StatusConflict, "user name already taken").SetInternal(err)
+}
+
+func (s server) userList() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		us := []database.User{}
+		if err := s.db.Order("name asc").Find(&us).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to get users from database, %w", err))
+		}
+		return c.JSON(http.StatusOK, us)
+	}
+}
+
+func (s server) userCreate() echo.HandlerFunc {
+	type input struct {
+		Name     string `json:"Name"`
+		Password string `json:"Password"`
+		IsAdmin  bool   `json:"IsAdmin"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		if i.Name == "" || i.Password == "" {
+			return echo.ErrBadRequest.SetInternal(errors.New("missing name or password"))
+		}
+
+		hash, err := database.HashPassword(i.Password)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		u := database.User{
+			Name:         i.Name,
+			PasswordHash: hash,
+			IsAdmin:      i.IsAdmin,
+		}
+		err = s.db.Create(&u).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errUserNameTaken(err)
+		}
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusCreated, u)
+	}
+}
+
+func (s server) userGet() echo.HandlerFunc {
+	type input struct {
+		ID uuid.UUID `param:"ID"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		var u database.User
+		if err := s.db.First(&u, i.ID).Error; err != nil {
+			return echo.NotFoundHandler(c)
+		}
+		return c.JSON(http.StatusOK, u)
+	}
+}
+
+func (s server) userUpdate() echo.HandlerFunc {
+	type input struct {
+		ID       uuid.UUID `param:"ID"`
+		Name     string    `json:"Name"`
+		IsAdmin  bool      `json:"IsAdmin"`
+		Disabled bool      `json:"Disabled"`
+		// Password is only changed, if set
+		Password string `json:"Password"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		if i.Name == "" {
+			return echo.ErrBadRequest.SetInternal(errors.New("missing name"))
+		}
+
+		current, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		var u database.User
+		if err := s.db.First(&u, i.ID).Error; err != nil {
+			return echo.NotFoundHandler(c)
+		}
+
+		// Prevent admins from locking themselves out
+		if u.ID == current.ID && (!i.IsAdmin || i.Disabled) {
+			return echo.ErrBadRequest.SetInternal(errors.New("unable to disable or demote yourself"))
+		}
+
+		u.Name = i.Name
+		u.IsAdmin = i.IsAdmin
+		u.Disabled = i.Disabled
+		if i.Password != "" {
+			hash, err := database.HashPassword(i.Password)
+			if err != nil {
+				return echo.ErrInternalServerError.SetInternal(err)
+			}
+			u.PasswordHash = hash
+		}
+
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(&u).Error; err != nil {
+				return fmt.Errorf("unable to update user, %w", err)
+			}
+			// Log out everywhere, if the credentials are changed or the user is disabled
+			if i.Password != "" || u.Disabled {
+				return revokeUserSessions(tx, u.ID)
+			}
+			return nil
+		})
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errUserNameTaken(err)
+		}
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+		return c.JSON(http.StatusOK, u)
+	}
+}
+
+func (s server) userDelete() echo.HandlerFunc {
+	type input struct {
+		ID uuid.UUID `param:"ID"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		current, err := userFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		var u database.User
+		if err := s.db.First(&u, i.ID).Error; err != nil {
+			return echo.NotFoundHandler(c)
+		}
+		if u.ID == current.ID {
+			return echo.ErrBadRequest.SetInternal(errors.New("unable to delete yourself"))
+		}
+
+		// Lists would be left without owner, so they have to be deleted
+		// first. Disabling the user is the alternative.
+		var owned int64
+		if err := s.db.Model(&database.List{}).Where("owner_id = ?", u.ID).Count(&owned).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to count owned lists, %w", err))
+		}
+		if owned > 0 {
+			return echo.NewHTTPError(http.StatusConflict, "user still owns lists")
+		}
+
+		// Delete for real, so the name can be used again
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Unscoped().Where("user_id = ?", u.ID).Delete(&database.ListMember{}).Error; err != nil {
+				return fmt.Errorf("unable to delete memberships, %w", err)
+			}
+			if err := tx.Unscoped().Where("user_id = ?", u.ID).Delete(&database.Session{}).Error; err != nil {
+				return fmt.Errorf("unable to delete sessions, %w", err)
+			}
+			if err := tx.Unscoped().Where("user_id = ?", u.ID).Delete(&database.APIToken{}).Error; err != nil {
+				return fmt.Errorf("unable to delete tokens, %w", err)
+			}
+			if err := tx.Unscoped().Where("user_id = ?", u.ID).Delete(&database.RecoveryCode{}).Error; err != nil {
+				return fmt.Errorf("unable to delete recovery codes, %w", err)
+			}
+			if err := tx.Unscoped().Delete(&u).Error; err != nil {
+				return fmt.Errorf("unable to delete user, %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to delete user %v, %w", u.ID, err))
+		}
+		return c.JSON(http.StatusOK, u)
+	}
+}
+
+func (s server) userUnlock() echo.HandlerFunc {
+	type input struct {
+		ID uuid.UUID `param:"ID"`
+	}
+	return func(c echo.Context) error {
+		var i input
+		if err := c.Bind(&i); err != nil {
+			return echo.ErrBadRequest.SetInternal(err)
+		}
+
+		var u database.User
+		if err := s.db.First(&u, i.ID).Error; err != nil {
+			return echo.NotFoundHandler(c)
+		}
+		u.FailedLogins = 0
+		u.LockedUntil = nil
+		if err := s.db.Model(&u).Select("failed_logins", "locked_until").Updates(&u).Error; err != nil {
+			return echo.ErrInternalServerError.SetInternal(fmt.Errorf("unable to unlock user %v, %w", u.ID, err))
+		}
+		return c.JSON(http.StatusOK, u)
+	}
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shaardie/listinator/database"
+)
+
+// admin creates an admin and returns the cookie of its session.
+func (env *testEnv) admin() *http.Cookie {
+	env.t.Helper()
+	cookie := env.user("admin")
+	if err := env.db.Model(&database.User{}).Where("name = ?", "admin").Update("is_admin", true).Error; err != nil {
+		env.t.Fatal(err)
+	}
+	return cookie
+}
+
+func TestUserCRUD(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.admin()
+	bob := env.user("bob")
+
+	// only admins manage users
+	if rec := env.do(http.MethodGet, "/api/v1/users", nil, bob); rec.Code != http.StatusForbidden {
+		t.Errorf("listing users as non-admin got %v, want 403", rec.Code)
+	}
+	if rec := env.do(http.MethodPost, "/api/v1/users", map[string]any{"Name": "carol", "Password": "secret"}, bob); rec.Code != http.StatusForbidden {
+		t.Errorf("creating user as non-admin got %v, want 403", rec.Code)
+	}
+
+	rec := env.do(http.MethodPost, "/api/v1/users", map[string]any{"Name": "carol", "Password": "secret"}, admin)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating user got %v, want 201", rec.Code)
+	}
+	var carol database.User
+	env.decode(rec, &carol)
+	if carol.Name != "carol" || carol.IsAdmin {
+		t.Errorf("got %+v, want user carol", carol)
+	}
+	if got := env.login("carol", "secret", "192.0.2.1"); got != http.StatusOK {
+		t.Errorf("login of new user got %v, want 200", got)
+	}
+	path := fmt.Sprintf("/api/v1/users/%v", carol.ID)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   map[string]any
+		want   int
+	}{
+		{"create without password", http.MethodPost, "/api/v1/users", map[string]any{"Name": "dave"}, http.StatusBadRequest},
+		{"create duplicate", http.MethodPost, "/api/v1/users", map[string]any{"Name": "bob", "Password": "secret"}, http.StatusConflict},
+		{"rename to taken name", http.MethodPut, path, map[string]any{"Name": "bob"}, http.StatusConflict},
+		{"update without name", http.MethodPut, path, map[string]any{"Name": ""}, http.StatusBadRequest},
+		{"get unknown", http.MethodGet, "/api/v1/users/" + uuid.NewString(), nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := env.do(tt.method, tt.path, tt.body, admin); rec.Code != tt.want {
+				t.Errorf("got %v, want %v", rec.Code, tt.want)
+			}
+		})
+	}
+
+	var us []database.User
+	env.decode(env.do(http.MethodGet, "/api/v1/users", nil, admin), &us)
+	if len(us) != 3 {
+		t.Errorf("got %v users, want admin, bob and carol", len(us))
+	}
+
+	// renaming, changing the password and disabling
+	rec = env.do(http.MethodPut, path, map[string]any{"Name": "caroline", "Password": "new secret"}, admin)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("updating user got %v, want 200", rec.Code)
+	}
+	env.decode(env.do(http.MethodGet, path, nil, admin), &carol)
+	if carol.Name != "caroline" {
+		t.Errorf("got name %v, want caroline", carol.Name)
+	}
+	if got := env.login("caroline", "new secret", "192.0.2.1"); got != http.StatusOK {
+		t.Errorf("login with new name and password got %v, want 200", got)
+	}
+	if rec := env.do(http.MethodPut, path, map[string]any{"Name": "caroline", "Disabled": true}, admin); rec.Code != http.StatusOK {
+		t.Fatalf("disabling user got %v, want 200", rec.Code)
+	}
+	if got := env.login("caroline", "new secret", "192.0.2.1"); got != http.StatusUnauthorized {
+		t.Errorf("login of disabled user got %v, want 401", got)
+	}
+
+	// admins can not lock themselves out
+	var a database.User
+	if err := env.db.First(&a, "name = ?", "admin").Error; err != nil {
+		t.Fatal(err)
+	}
+	self := fmt.Sprintf("/api/v1/users/%v", a.ID)
+	if rec := env.do(http.MethodPut, self, map[string]any{"Name": "admin", "IsAdmin": false}, admin); rec.Code != http.StatusBadRequest {
+		t.Errorf("demoting yourself got %v, want 400", rec.Code)
+	}
+	if rec := env.do(http.MethodDelete, self, nil, admin); rec.Code != http.StatusBadRequest {
+		t.Errorf("deleting yourself got %v, want 400", rec.Code)
+	}
+
+	// deleting frees the name
+	if rec := env.do(http.MethodDelete, path, nil, admin); rec.Code != http.StatusOK {
+		t.Fatalf("deleting user got %v, want 200", rec.Code)
+	}
+	if rec := env.do(http.MethodGet, path, nil, admin); rec.Code != http.StatusNotFound {
+		t.Errorf("getting deleted user got %v, want 404", rec.Code)
+	}
+	if rec := env.do(http.MethodPost, "/api/v1/users", map[string]any{"Name": "caroline", "Password": "secret"}, admin); rec.Code != http.StatusCreated {
+		t.Errorf("reusing name of deleted user got %v, want 201", rec.Code)
+	}
+}
+
+func TestUserDeleteOwner(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.admin()
+	bob := env.user("bob")
+	l, _ := env.list(bob)
+	var u database.User
+	if err := env.db.First(&u, "name = ?", "bob").Error; err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/api/v1/users/%v", u.ID)
+
+	if rec := env.do(http.MethodDelete, path, nil, admin); rec.Code != http.StatusConflict {
+		t.Errorf("deleting owner of a list got %v, want 409", rec.Code)
+	}
+	if rec := env.do(http.MethodDelete, fmt.Sprintf("/api/v1/lists/%v", l.ID), nil, bob); rec.Code != http.StatusOK {
+		t.Fatalf("deleting list failed with %v", rec.Code)
+	}
+	if rec := env.do(http.MethodDelete, path, nil, admin); rec.Code != http.StatusOK {
+		t.Errorf("deleting user without lists got %v, want 200", rec.Code)
+	}
+}
+
+func TestUserUnlock(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.admin()
+	env.user("bob")
+	until := time.Now().Add(time.Hour)
+	if err := env.db.Model(&database.User{}).Where("name = ?", "bob").Updates(map[string]any{"failed_logins": 10, "locked_until": until}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var u database.User
+	if err := env.db.First(&u, "name = ?", "bob").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	rec := env.do(http.MethodPost, fmt.Sprintf("/api/v1/users/%v/unlock", u.ID), nil, admin)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unlock got %v, want 200", rec.Code)
+	}
+	var unlocked database.User
+	if err := env.db.First(&unlocked, u.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if unlocked.FailedLogins != 0 || unlocked.LockedUntil != nil {
+		t.Errorf("got %v failed logins and locked until %v after unlock", unlocked.FailedLogins, unlocked.LockedUntil)
+	}
+	if rec := env.do(http.MethodPost, fmt.Sprintf("/api/v1/users/%v/unlock", uuid.New()), nil, admin); rec.Code != http.StatusNotFound {
+		t.Errorf("unlocking unknown user got %v, want 404", rec.Code)
+	}
+}
+
+func TestUserTOTPReset(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.admin()
+	bob := env.user("bob")
+	var u database.User
+	if err := env.db.First(&u, "name = ?", "bob").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := env.db.Model(&u).Updates(map[string]any{"totp_secret": "JBSWY3DPEHPK3PXP", "totp_enabled": true}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := env.db.Create(&database.RecoveryCode{UserID: u.ID, CodeHash: "hash"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := env.login("bob", "secret", "192.0.2.1"); got != http.StatusAccepted {
+		t.Fatalf("login with TOTP got %v, want 202", got)
+	}
+
+	path := fmt.Sprintf("/api/v1/users/%v/totp", u.ID)
+	if rec := env.do(http.MethodDelete, path, nil, bob); rec.Code != http.StatusForbidden {
+		t.Errorf("reset by non-admin got %v, want 403", rec.Code)
+	}
+	if rec := env.do(http.MethodDelete, path, nil, admin); rec.Code != http.StatusNoContent {
+		t.Fatalf("reset got %v, want 204", rec.Code)
+	}
+
+	if err := env.db.First(&u, u.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if u.TOTPEnabled || u.TOTPSecret != "" {
+		t.Errorf("TOTP still enabled after reset")
+	}
+	var codes int64
+	if err := env.db.Unscoped().Model(&database.RecoveryCode{}).Where("user_id = ?", u.ID).Count(&codes).Error; err != nil {
+		t.Fatal(err)
+	}
+	if codes != 0 {
+		t.Errorf("got %v recovery codes after reset, want 0", codes)
+	}
+	if got := env.login("bob", "secret", "192.0.2.1"); got != http.StatusOK {
+		t.Errorf("login after reset got %v, want 200", got)
+	}
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shaardie/listinator/database"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// wsMaxPayload is the maximum size of a message from the client.
+	wsMaxPayload = 64 << 10
+	// wsPingInterval is the interval in which the server pings the client.
+	wsPingInterval = 30 * time.Second
+	// wsPongWait is the time after which a client, which sent nothing, not
+	// even a pong, is considered dead.
+	wsPongWait = 2 * wsPingInterval
+)
+
+// wsCommand is a command sent by the client over the WebSocket.
+type wsCommand struct {
+	// ID is chosen by the client and returned in the acknowledgement or the
+	// error of the command.
+	ID string
+	// Command is one of create, update, delete or toggle or pong as answer
+	// to a ping.
+	Command string
+	// EntryID is the entry to update, delete or toggle.
+	EntryID uuid.UUID
+	// Entry contains the fields for create and update.
+	Entry entryInput
+}
+
+// wsMessage is a message sent by the server over the WebSocket.
+type wsMessage struct {
+	// Type is event, ack, error or ping, which the client has to answer
+	// with the pong command.
+	Type string
+	// ID of the command for ack and error.
+	ID string `json:",omitempty"`
+	// Event is the envelope of the event, the same as sent over SSE.
+	Event *event `json:",omitempty"`
+	// Entry affected by the command.
+	Entry *database.Entry `json:",omitempty"`
+	// Code and Message of the error, similar to the REST API.
+	Code    int    `json:",omitempty"`
+	Message string `json:",omitempty"`
+}
+
+// wsError converts an error of the shared entry logic to an error message.
+func wsError(id string, err error) wsMessage {
+	var he *echo.HTTPError
+	if !errors.As(err, &he) {
+		he = echo.ErrInternalServerError.WithInternal(err)
+	}
+	if he.Internal != nil {
+		slog.Error("websocket command failed", "command", id, "error", he.Internal)
+	}
+	msg := wsMessage{
+		Type:    "error",
+		ID:      id,
+		Code:    he.Code,
+		Message: fmt.Sprint(he.Message),
+	}
+	// Conflicts carry the current entry
+	if e, ok := he.Message.(database.Entry); ok {
+		msg.Entry = &e
+		msg.Message = errVersionConflict.Error()
+	}
+	return msg
+}
+
+// wsCheckOrigin prevents cross-site WebSocket hijacking. Browsers always send
+// the Origin header, other clients do not carry the cookies of the user.
+func wsCheckOrigin(config *websocket.Config, req *http.Request) error {
+	origin, err := websocket.Origin(config, req)
+	if err != nil {
+		return err
+	}
+	if origin != nil && origin.Host != req.Host {
+		return fmt.Errorf("origin %v does not match host %v", origin, req.Host)
+	}
+	config.Origin = origin
+	return nil
+}
+
+// wsHandleCommand runs a command of the client on the list of the access a
+// and returns the answer, which is empty for pongs.
+func (s server) wsHandleCommand(c echo.Context, a *access, data []byte) wsMessage {
+	var cmd wsCommand
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return wsError("", echo.ErrBadRequest.WithInternal(err))
+	}
+
+	switch cmd.Command {
+	case "pong":
+		return wsMessage{}
+	case "create", "update", "delete", "toggle":
+	default:
+		return wsError(cmd.ID, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown command %q", cmd.Command)))
+	}
+	if !a.Role.Allows(database.RoleEditor) {
+		return wsError(cmd.ID, echo.ErrForbidden)
+	}
+
+	if cmd.Command == "create" {
+		if cmd.Entry.ListID == uuid.Nil {
+			cmd.Entry.ListID = a.List.ID
+		}
+		if cmd.Entry.ListID != a.List.ID {
+			return wsError(cmd.ID, echo.NewHTTPError(http.StatusBadRequest, "entries can only be created in the list of the connection"))
+		}
+		e, err := s.createEntry(c, cmd.Entry)
+		if err != nil {
+			return wsError(cmd.ID, err)
+		}
+		return wsMessage{Type: "ack", ID: cmd.ID, Entry: &e}
+	}
+
+	// All other commands work on an existing entry of this list
+	var e database.Entry
+	if err := s.db.Where("list_id = ?", a.List.ID).First(&e, cmd.EntryID).Error; err != nil {
+		return wsError(cmd.ID, echo.ErrNotFound)
+	}
+
+	var err error
+	switch cmd.Command {
+	case "update":
+		if cmd.Entry.ListID == uuid.Nil {
+			cmd.Entry.ListID = e.ListID
+		}
+		e, err = s.updateEntry(c, e, cmd.Entry)
+	case "delete":
+		err = s.deleteEntry(c, e)
+	case "toggle":
+		e, err = s.updateEntry(c, e, entryInput{
+			Name:   e.Name,
+			Number: e.Number,
+			Bought: !e.Bought,
+			TypeID: e.TypeID,
+			ListID: e.ListID,
+		})
+	}
+	if err != nil {
+		return wsError(cmd.ID, err)
+	}
+	return wsMessage{Type: "ack", ID: cmd.ID, Entry: &e}
+}
+
+// listWebSocket streams the entry events of the list like entryGetEvents and
+// accepts commands to change the entries.
+func (s server) listWebSocket() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		a, err := accessFromContext(c)
+		if err != nil {
+			return echo.ErrInternalServerError.SetInternal(err)
+		}
+
+		handler := func(ws *websocket.Conn) {
+			defer ws.Close()
+			ws.MaxPayloadBytes = wsMaxPayload
+
+			// subscribe to the pubsub channel for this list
+			id, ch, err := s.eventPubSub.Subscribe(a.List.ID)
+			if err != nil {
+				slog.Error("unable to subscribe", "error", err)
+				return
+			}
+			// unsubscribe after return
+			defer s.eventPubSub.Unsubscribe(a.List.ID, id)
+
+			// subscribe to the presence of the others before joining, so no
+			// presence event gets lost
+			pid, pch, err := s.presencePubSub.Subscribe(a.List.ID)
+			if err != nil {
+				slog.Error("unable to subscribe to presence", "error", err)
+				return
+			}
+			defer s.presencePubSub.Unsubscribe(a.List.ID, pid)
+			self, unwatch, err := s.watch(c, a, id, transportWebSocket)
+			if err != nil {
+				slog.Error("unable to watch list", "error", err)
+				return
+			}
+			defer unwatch()
+
+			// tell the client who else is here
+			snapshot, err := newEvent(eventPresenceSnapshot, a.List.ID, nil, s.othersWatching(a, self))
+			if err != nil {
+				slog.Error("unable to create presence snapshot", "error", err)
+				return
+			}
+			if err := websocket.JSON.Send(ws, wsMessage{Type: "event", Event: &snapshot}); err != nil {
+				return
+			}
+
+			// receive commands from the client until the connection is closed
+			// or the client stops answering the pings
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for {
+					if err := ws.SetReadDeadline(time.Now().Add(wsPongWait)); err != nil {
+						return
+					}
+					var data []byte
+					if err := websocket.Message.Receive(ws, &data); err != nil {
+						return
+					}
+					current, err := s.recheckAccess(c, a.List.ID)
+					if err != nil {
+						websocket.JSON.Send(ws, wsError("", err))
+						return
+					}
+					msg := s.wsHandleCommand(c, current, data)
+					if msg.Type == "" {
+						continue
+					}
+					if err := websocket.JSON.Send(ws, msg); err != nil {
+						return
+					}
+				}
+			}()
+			// the echo context must not be used after the handler returned
+			defer func() {
+				ws.Close()
+				<-done
+			}()
+
+			ping := time.NewTicker(wsPingInterval)
+			defer ping.Stop()
+			// lastSeq is the sequence number of the last event sent, zero
+			// before the first one
+			var lastSeq int64
+			for {
+				select {
+				case <-done:
+					return
+				case <-ping.C:
+					if err := websocket.JSON.Send(ws, wsMessage{Type: "ping"}); err != nil {
+						return
+					}
+				// the server is going away, tell the client when to come back
+				case <-s.shutdown:
+					ev, err := newRestartEvent(a.List.ID)
+					if err != nil {
+						slog.Error("unable to create restart event", "error", err)
+						return
+					}
+					websocket.JSON.Send(ws, wsMessage{Type: "event", Event: &ev})
+					return
+				// receive the presence of the others and send it to the client
+				case pe, ok := <-pch:
+					if !ok {
+						return
+					}
+					if pe.Watcher.ID == self.ID {
+						continue
+					}
+					ev, err := pe.envelope(a.List.ID)
+					if err != nil {
+						slog.Error("unable to create presence event", "error", err)
+						return
+					}
+					if err := websocket.JSON.Send(ws, wsMessage{Type: "event", Event: &ev}); err != nil {
+						return
+					}
+				// receive data from pubsub channel and send them to the client
+				case ev, ok := <-ch:
+					// channel was closed because we could not keep up (see
+					// pubsub.Policy), the client has to reconnect
+					if !ok {
+						return
+					}
+					// replay the events the pubsub lost
+					events := []event{ev}
+					if lastSeq > 0 {
+						events, err = s.catchUp(ev, lastSeq)
+						if err != nil {
+							if !errors.Is(err, errEventLogTruncated) {
+								slog.Error("unable to replay events", "error", err)
+							}
+							if resync, err := newEvent(eventResync, a.List.ID, nil, nil); err == nil {
+								websocket.JSON.Send(ws, wsMessage{Type: "event", Event: &resync})
+							}
+							return
+						}
+					}
+					for _, ev := range events {
+						if err := websocket.JSON.Send(ws, wsMessage{Type: "event", Event: &ev}); err != nil {
+							return
+						}
+						if ev.Seq > 0 {
+							lastSeq = ev.Seq
+						}
+						// the list is gone, there is nothing left to stream
+						if ev.Type == eventListDeleted {
+							return
+						}
+					}
+				}
+			}
+		}
+
+		// the connection gets hijacked, so the http server does not wait for
+		// it on shutdown
+		s.running.Add(1)
+		defer s.running.Done()
+		websocket.Server{
+			Handshake: wsCheckOrigin,
+			Handler:   handler,
+		}.ServeHTTP(c.Response(), c.Request())
+		return nil
+	}
+}
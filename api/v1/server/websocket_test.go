@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shaardie/listinator/database"
+	"golang.org/x/net/websocket"
+)
+
+// receiveWS returns the next message from the WebSocket, which is not an
+// event, or fails after a second.
+func receiveWS(t *testing.T, ws *websocket.Conn) (wsMessage, error) {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		var msg wsMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return msg, err
+		}
+		if msg.Type != "event" {
+			return msg, nil
+		}
+	}
+}
+
+// dialWS connects to the WebSocket of the list with the session cookie.
+func dialWS(t *testing.T, srv *httptest.Server, listID string, cookie *http.Cookie) *websocket.Conn {
+	t.Helper()
+	cfg, err := websocket.NewConfig(strings.Replace(srv.URL, "http", "ws", 1)+"/api/v1/lists/"+listID+"/ws", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Header = http.Header{"Cookie": {cookie.String()}}
+	ws, err := websocket.DialConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+func TestWebSocketRevokedAccess(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	editor := env.user("editor")
+	l, _ := env.list(owner)
+
+	var u database.User
+	if err := env.db.First(&u, "name = ?", "editor").Error; err != nil {
+		t.Fatal(err)
+	}
+	m := database.ListMember{ListID: l.ID, UserID: u.ID, Role: database.RoleEditor}
+	if err := env.db.Create(&m).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(env.e)
+	defer srv.Close()
+	ws := dialWS(t, srv, l.ID.String(), editor)
+	defer ws.Close()
+
+	create := map[string]any{"ID": "1", "Command": "create", "Entry": map[string]any{"Name": "bread"}}
+	if err := websocket.JSON.Send(ws, create); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := receiveWS(t, ws); err != nil || msg.Type != "ack" {
+		t.Fatalf("create as editor got %+v, %v", msg, err)
+	}
+
+	// The editor is removed from the list while connected
+	if err := env.db.Unscoped().Delete(&m).Error; err != nil {
+		t.Fatal(err)
+	}
+	create["ID"] = "2"
+	if err := websocket.JSON.Send(ws, create); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := receiveWS(t, ws); err != nil || msg.Type != "error" || msg.Code != http.StatusForbidden {
+		t.Fatalf("create after removal got %+v, %v", msg, err)
+	}
+	if msg, err := receiveWS(t, ws); err == nil {
+		t.Errorf("connection still open, got %+v", msg)
+	}
+
+	var n int64
+	if err := env.db.Model(&database.Entry{}).Where("name = ?", "bread").Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("%v entries created, want 1", n)
+	}
+}
+
+func TestWebSocketShutdown(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.user("owner")
+	l, _ := env.list(owner)
+
+	srv := httptest.NewServer(env.e)
+	defer srv.Close()
+	ws := dialWS(t, srv, l.ID.String(), owner)
+	defer ws.Close()
+	// the presence snapshot tells that the handler is running
+	var msg wsMessage
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	env.s.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := env.s.Wait(ctx); err != nil {
+		t.Fatalf("waiting for the WebSocket handler failed, %v", err)
+	}
+	if err := websocket.JSON.Receive(ws, &msg); err != nil || msg.Event == nil || msg.Event.Type != eventServerRestarting {
+		t.Errorf("got %+v, %v, want a restart event", msg, err)
+	}
+}
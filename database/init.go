@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"os"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -14,6 +13,8 @@ func Init(dsn string) (*gorm.DB, error) {
 
 	db, err := gorm.Open(dialector, &gorm.Config{
 		Logger: &slogLogger{},
+		// Translate errors like unique constraint violations into gorm errors
+		TranslateError: true,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("unable to open database, %w", err)
@@ -31,16 +32,19 @@ func Init(dsn string) (*gorm.DB, error) {
 	// Create admin user with password or update, if already present.
 	adminPassword := os.Getenv("LISTINATOR_ADMIN_PASSWORD")
 	if adminPassword != "" {
-		hash, err := bcrypt.GenerateFromPassword([]byte(adminPassword), bcrypt.DefaultCost)
+		hash, err := HashPassword(adminPassword)
 		if err != nil {
 			return nil, fmt.Errorf("unable to hash admin password, %w", err)
 		}
 
-		// This can probably be done nicer, but I think the race condition is not that important during startup
-		admin := User{Name: "admin", PasswordHash: string(hash), IsAdmin: true}
-		x := db.Model(&admin).Where("name = ?", "admin").Updates(&admin)
+		// This can probably be done nicer, but I think the race condition is not that important during startup.
+		// Restarting also unlocks the admin, in case it was locked by failed logins.
+		admin := User{Name: "admin", PasswordHash: hash, IsAdmin: true}
+		x := db.Model(&admin).Where("name = ?", "admin").
+			Select("password_hash", "is_admin", "failed_logins", "locked_until").
+			Updates(&admin)
 		if x.Error != nil {
-			return nil, fmt.Errorf("unable to update admin, %w", err)
+			return nil, fmt.Errorf("unable to update admin, %w", x.Error)
 		}
 		if x.RowsAffected == 0 {
 			if err := db.Create(&admin).Error; err != nil {
@@ -49,5 +53,43 @@ func Init(dsn string) (*gorm.DB, error) {
 		}
 	}
 
+	if err := adoptOwnerlessLists(db); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
+
+// adoptOwnerlessLists hands lists without owner over to the admin. Lists
+// created by guests in older versions are adopted by the migration already,
+// but only if there was an admin at that time, so this catches up once an
+// admin exists.
+func adoptOwnerlessLists(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		ls := []List{}
+		if err := tx.Where("owner_id IS NULL").Find(&ls).Error; err != nil {
+			return fmt.Errorf("unable to get lists without owner, %w", err)
+		}
+		if len(ls) == 0 {
+			return nil
+		}
+		admins := []User{}
+		if err := tx.Where("is_admin").Order("name = 'admin' DESC").Order("created_at").Limit(1).Find(&admins).Error; err != nil {
+			return fmt.Errorf("unable to get admin, %w", err)
+		}
+		if len(admins) == 0 {
+			return nil
+		}
+		admin := admins[0]
+		for _, l := range ls {
+			if err := tx.Model(&l).Update("owner_id", admin.ID).Error; err != nil {
+				return fmt.Errorf("unable to adopt list %v, %w", l.ID, err)
+			}
+			m := ListMember{ListID: l.ID, UserID: admin.ID, Role: RoleOwner}
+			if err := tx.Where("list_id = ? AND user_id = ?", l.ID, admin.ID).FirstOrCreate(&m).Error; err != nil {
+				return fmt.Errorf("unable to add admin to list %v, %w", l.ID, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAdoptOwnerlessLists(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "listinator.db")
+	t.Setenv("LISTINATOR_ADMIN_PASSWORD", "")
+	db, err := Init(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a guest list from an older version without an admin to adopt it
+	l := List{Name: "guest"}
+	if err := db.Create(&l).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := adoptOwnerlessLists(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.First(&l, l.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if l.OwnerID != nil {
+		t.Fatalf("list adopted by %v without admin", l.OwnerID)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	// the admin is created on the next start and adopts the list
+	t.Setenv("LISTINATOR_ADMIN_PASSWORD", "secret")
+	db, err = Init(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}()
+	var admin User
+	if err := db.First(&admin, "name = ?", "admin").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.First(&l, l.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if l.OwnerID == nil || *l.OwnerID != admin.ID {
+		t.Errorf("list owned by %v, want the admin %v", l.OwnerID, admin.ID)
+	}
+	var m ListMember
+	if err := db.First(&m, "list_id = ? AND user_id = ?", l.ID, admin.ID).Error; err != nil {
+		t.Fatalf("admin is no member, %v", err)
+	}
+	if m.Role != RoleOwner {
+		t.Errorf("admin has role %q, want owner", m.Role)
+	}
+}
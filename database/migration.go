@@ -1,16 +1,22 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"embed"
 	"fmt"
 
 	"github.com/pressly/goose/v3"
+	"github.com/shaardie/listinator/quantity"
 )
 
 //go:embed migrations/*.sql
 var embedMigrations embed.FS
 
+func init() {
+	goose.AddNamedMigrationContext("20261018081700_parse_quantities.go", parseQuantities, nil)
+}
+
 func migrate(db *sql.DB) error {
 	goose.SetBaseFS(embedMigrations)
 
@@ -24,3 +30,37 @@ func migrate(db *sql.DB) error {
 
 	return nil
 }
+
+// parseQuantities parses the quantities of the entries created before
+// quantities were parsed. It runs once as migration, entries whose number is
+// not a quantity are not looked at again.
+func parseQuantities(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id, number FROM entries WHERE amount IS NULL AND number != ''")
+	if err != nil {
+		return fmt.Errorf("unable to get entries without quantity, %w", err)
+	}
+	numbers := map[string]string{}
+	for rows.Next() {
+		var id, number string
+		if err := rows.Scan(&id, &number); err != nil {
+			rows.Close()
+			return fmt.Errorf("unable to scan entry, %w", err)
+		}
+		numbers[id] = number
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("unable to get entries without quantity, %w", err)
+	}
+
+	for id, number := range numbers {
+		q, err := quantity.Parse(number)
+		if err != nil {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE entries SET amount = ?, unit = ? WHERE id = ?", q.Amount, string(q.Unit), id); err != nil {
+			return fmt.Errorf("unable to update quantity of entry %v, %w", id, err)
+		}
+	}
+	return nil
+}
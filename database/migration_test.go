@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/shaardie/listinator/quantity"
+)
+
+func TestParseQuantities(t *testing.T) {
+	t.Setenv("LISTINATOR_ADMIN_PASSWORD", "")
+	db, err := Init(filepath.Join(t.TempDir(), "listinator.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	l := List{Name: "groceries"}
+	if err := db.Create(&l).Error; err != nil {
+		t.Fatal(err)
+	}
+	// entries from an older version without quantities
+	numbers := map[string]string{
+		"milk":   "1.5 l",
+		"sugar":  "0.1 mg",
+		"eggs":   "6",
+		"cheese": "a slice",
+		"bread":  "",
+	}
+	for name, number := range numbers {
+		if err := db.Create(&Entry{Name: name, Number: number, ListID: l.ID}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx, err := sqlDB.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parseQuantities(context.Background(), tx); err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"milk":   "1.5 l",
+		"sugar":  "0.1 mg",
+		"eggs":   "6",
+		"cheese": "",
+		"bread":  "",
+	}
+	var es []Entry
+	if err := db.Where("list_id = ?", l.ID).Find(&es).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != len(want) {
+		t.Fatalf("got %v entries, want %v", len(es), len(want))
+	}
+	for _, e := range es {
+		got := ""
+		if e.Amount != nil {
+			got = quantity.Quantity{Amount: *e.Amount, Unit: e.Unit}.String()
+		} else if e.Unit != "" {
+			got = "unit " + string(e.Unit)
+		}
+		if got != want[e.Name] {
+			t.Errorf("%v got quantity %q, want %q", e.Name, got, want[e.Name])
+		}
+	}
+}
@@ -0,0 +1,8 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE lists ADD COLUMN name text NOT NULL DEFAULT '';
+ALTER TABLE lists ADD COLUMN description text NOT NULL DEFAULT '';
+ALTER TABLE lists ADD COLUMN archived numeric NOT NULL DEFAULT 0;
+ALTER TABLE lists ADD COLUMN owner_id text REFERENCES users(id);
+CREATE INDEX IF NOT EXISTS idx_lists_owner_id ON lists(owner_id);
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS list_members (
+  id text,
+  created_at datetime,
+  updated_at datetime,
+  deleted_at datetime,
+  list_id text NOT NULL,
+  user_id text NOT NULL,
+  role text NOT NULL,
+  PRIMARY KEY (id),
+  CONSTRAINT fk_lists_members FOREIGN KEY (list_id) REFERENCES lists(id),
+  CONSTRAINT fk_list_members_user FOREIGN KEY (user_id) REFERENCES users(id),
+  CONSTRAINT uni_list_members_list_user UNIQUE (list_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_list_members_deleted_at ON list_members(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_list_members_user_id ON list_members(user_id);
+
+-- owners are members of their lists
+INSERT INTO list_members (id, created_at, updated_at, deleted_at, list_id, user_id, role)
+SELECT lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-8' || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6))),
+       CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, NULL, id, owner_id, 'owner'
+FROM lists WHERE owner_id IS NOT NULL AND deleted_at IS NULL;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS list_shares (
+  id text,
+  created_at datetime,
+  updated_at datetime,
+  deleted_at datetime,
+  list_id text NOT NULL,
+  token_hash text NOT NULL,
+  role text NOT NULL,
+  expires_at datetime,
+  max_uses integer NOT NULL DEFAULT 0,
+  uses integer NOT NULL DEFAULT 0,
+  PRIMARY KEY (id),
+  CONSTRAINT fk_lists_shares FOREIGN KEY (list_id) REFERENCES lists(id),
+  CONSTRAINT uni_list_shares_token_hash UNIQUE (token_hash)
+);
+CREATE INDEX IF NOT EXISTS idx_list_shares_deleted_at ON list_shares(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_list_shares_list_id ON list_shares(list_id);
+-- +goose StatementEnd
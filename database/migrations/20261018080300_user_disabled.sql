@@ -0,0 +1,4 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN disabled numeric NOT NULL DEFAULT 0;
+-- +goose StatementEnd
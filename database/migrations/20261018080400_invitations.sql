@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS invitations (
+  id text,
+  created_at datetime,
+  updated_at datetime,
+  deleted_at datetime,
+  code_hash text NOT NULL,
+  created_by_id text NOT NULL,
+  expires_at datetime,
+  used_by_id text,
+  used_at datetime,
+  PRIMARY KEY (id),
+  CONSTRAINT fk_invitations_created_by FOREIGN KEY (created_by_id) REFERENCES users(id),
+  CONSTRAINT uni_invitations_code_hash UNIQUE (code_hash)
+);
+CREATE INDEX IF NOT EXISTS idx_invitations_deleted_at ON invitations(deleted_at);
+-- +goose StatementEnd
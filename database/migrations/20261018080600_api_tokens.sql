@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS api_tokens (
+  id text,
+  created_at datetime,
+  updated_at datetime,
+  deleted_at datetime,
+  user_id text NOT NULL,
+  name text NOT NULL,
+  token_hash text NOT NULL,
+  scopes text NOT NULL,
+  expires_at datetime,
+  last_used_at datetime,
+  PRIMARY KEY (id),
+  CONSTRAINT fk_api_tokens_user FOREIGN KEY (user_id) REFERENCES users(id),
+  CONSTRAINT uni_api_tokens_token_hash UNIQUE (token_hash)
+);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_deleted_at ON api_tokens(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+-- +goose StatementEnd
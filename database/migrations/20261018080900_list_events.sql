@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS list_events (
+  list_id text NOT NULL,
+  seq integer NOT NULL,
+  created_at datetime,
+  action text NOT NULL,
+  data text NOT NULL,
+  PRIMARY KEY (list_id, seq)
+);
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The id orders the events of all lists, so other instances can poll them.
+CREATE TABLE list_events_new (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  list_id text NOT NULL,
+  seq integer NOT NULL,
+  created_at datetime,
+  action text NOT NULL,
+  data text NOT NULL,
+  UNIQUE (list_id, seq)
+);
+INSERT INTO list_events_new (list_id, seq, created_at, action, data)
+  SELECT list_id, seq, created_at, action, data FROM list_events ORDER BY created_at, seq;
+DROP TABLE list_events;
+ALTER TABLE list_events_new RENAME TO list_events;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE list_events RENAME COLUMN action TO type;
+ALTER TABLE list_events RENAME COLUMN data TO payload;
+ALTER TABLE list_events ADD COLUMN version integer NOT NULL DEFAULT 1;
+ALTER TABLE list_events ADD COLUMN actor_id text;
+
+-- The payload of the old events is a superset of the new entry payload
+UPDATE list_events SET type = 'entry.created' WHERE type = 'create';
+UPDATE list_events SET type = 'entry.updated' WHERE type = 'update';
+UPDATE list_events SET type = 'entry.deleted' WHERE type = 'delete';
+-- +goose StatementEnd
@@ -0,0 +1,4 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE entries ADD COLUMN version integer NOT NULL DEFAULT 1;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS list_operations (
+  id text,
+  created_at datetime,
+  updated_at datetime,
+  deleted_at datetime,
+  list_id text NOT NULL,
+  user_id text,
+  kind text NOT NULL,
+  entry_ids text NOT NULL,
+  undone_at datetime,
+  PRIMARY KEY (id),
+  CONSTRAINT fk_list_operations_list FOREIGN KEY (list_id) REFERENCES lists(id)
+);
+CREATE INDEX IF NOT EXISTS idx_list_operations_deleted_at ON list_operations(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_list_operations_list_id ON list_operations(list_id);
+-- +goose StatementEnd
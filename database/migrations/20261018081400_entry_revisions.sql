@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS entry_revisions (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  created_at datetime,
+  entry_id text NOT NULL,
+  list_id text NOT NULL,
+  action text NOT NULL,
+  before_state text,
+  after_state text,
+  user_id text,
+  share_id text,
+  client_ip text NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_entry_revisions_entry_id ON entry_revisions(entry_id);
+CREATE INDEX IF NOT EXISTS idx_entry_revisions_list_id ON entry_revisions(list_id);
+-- +goose StatementEnd
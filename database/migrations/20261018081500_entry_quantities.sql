@@ -0,0 +1,5 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE entries ADD COLUMN amount real;
+ALTER TABLE entries ADD COLUMN unit text NOT NULL DEFAULT '';
+-- +goose StatementEnd
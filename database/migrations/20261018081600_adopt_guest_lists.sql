@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Lists without owner were editable by everybody knowing their id. They are
+-- handed over to the admin, who can share them again.
+UPDATE lists SET owner_id = (SELECT id FROM users WHERE is_admin AND deleted_at IS NULL ORDER BY name = 'admin' DESC, created_at LIMIT 1)
+WHERE owner_id IS NULL;
+
+INSERT INTO list_members (id, created_at, updated_at, deleted_at, list_id, user_id, role)
+SELECT lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-8' || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6))),
+       CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, NULL, id, owner_id, 'owner'
+FROM lists WHERE owner_id IS NOT NULL AND deleted_at IS NULL
+  AND NOT EXISTS (SELECT 1 FROM list_members m WHERE m.list_id = lists.id AND m.user_id = lists.owner_id);
+-- +goose StatementEnd
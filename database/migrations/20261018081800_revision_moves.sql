@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE entry_revisions ADD COLUMN from_list_id text;
+CREATE INDEX IF NOT EXISTS idx_entry_revisions_from_list_id ON entry_revisions(from_list_id);
+
+-- Moves were only recorded in the target list
+UPDATE entry_revisions SET from_list_id = json_extract(before_state, '$.ListID')
+WHERE action = 'updated' AND json_extract(before_state, '$.ListID') != list_id;
+-- +goose StatementEnd
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/shaardie/listinator/quantity"
 	"gorm.io/gorm"
 )
 
@@ -27,19 +28,216 @@ type User struct {
 	Name         string
 	PasswordHash string `json:"-"`
 	IsAdmin      bool
+	// Disabled users are not able to log in anymore.
+	Disabled bool
+
+	// FailedLogins counts the failed logins since the last successful one.
+	FailedLogins int `json:"-"`
+	// LockedUntil is set, if there were too many failed logins.
+	LockedUntil *time.Time
+
+	// TOTPSecret is set during the enrollment and kept while TOTP is enabled.
+	TOTPSecret  string `json:"-"`
+	TOTPEnabled bool
+	// TOTPLastStep is the time step of the last used code, so it can not be used again.
+	TOTPLastStep int64 `json:"-"`
+}
+
+// Locked reports whether the user is locked at time t.
+func (u User) Locked(t time.Time) bool {
+	return u.LockedUntil != nil && t.Before(*u.LockedUntil)
 }
 
 type List struct {
 	Model
 
+	Name        string
+	Description string
+	Archived    bool
+
+	// OwnerID is nil only for lists created by guests in older versions
+	// until they are handed over to the admin, see adoptOwnerlessLists.
+	OwnerID *uuid.UUID
+	Owner   *User `json:"-"`
+
 	Entries []Entry
 }
 
+// RecoveryCode can be used once instead of a TOTP code. Used codes are deleted.
+type RecoveryCode struct {
+	Model
+
+	UserID   uuid.UUID
+	CodeHash string
+}
+
+// Session is a login of a user. Deleting a session revokes it.
+type Session struct {
+	Model
+
+	UserID     uuid.UUID
+	User       User `json:"-"`
+	UserAgent  string
+	IP         string
+	LastSeenAt time.Time
+}
+
+const (
+	// ScopeRead allows reading requests with an API token.
+	ScopeRead = "read"
+	// ScopeWrite allows all other requests with an API token.
+	ScopeWrite = "write"
+)
+
+// APIToken is a bearer token for scripts. Deleting a token revokes it.
+type APIToken struct {
+	Model
+
+	UserID     uuid.UUID
+	User       User `json:"-"`
+	Name       string
+	TokenHash  string   `json:"-"`
+	Scopes     []string `gorm:"serializer:json"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+// Invitation allows to register a single new user.
+type Invitation struct {
+	Model
+
+	CodeHash    string `json:"-"`
+	CreatedByID uuid.UUID
+	ExpiresAt   *time.Time
+	UsedByID    *uuid.UUID
+	UsedAt      *time.Time
+}
+
+// Role is the role of a user on a list.
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleOwner  Role = "owner"
+)
+
+func (r Role) rank() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleEditor:
+		return 2
+	case RoleOwner:
+		return 3
+	}
+	return 0
+}
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	return r.rank() > 0
+}
+
+// Allows reports whether r grants at least the permissions of min.
+func (r Role) Allows(min Role) bool {
+	return r.Valid() && r.rank() >= min.rank()
+}
+
+type ListMember struct {
+	Model
+
+	ListID uuid.UUID
+	UserID uuid.UUID
+	User   User `json:"-"`
+	Role   Role
+}
+
+// ListShare grants access to a list without being a member.
+// Deleting a share revokes it.
+type ListShare struct {
+	Model
+
+	ListID    uuid.UUID
+	TokenHash string `json:"-"`
+	Role      Role
+	ExpiresAt *time.Time
+	// MaxUses limits how often the share can be redeemed, 0 means unlimited.
+	MaxUses int
+	Uses    int
+}
+
+// Expired reports whether the share is expired at time t.
+func (s ListShare) Expired(t time.Time) bool {
+	return s.ExpiresAt != nil && !t.Before(*s.ExpiresAt)
+}
+
+// EntryRevision records a change of an entry and who made it.
+type EntryRevision struct {
+	ID        int64 `gorm:"primaryKey"`
+	CreatedAt time.Time
+	EntryID   uuid.UUID
+	ListID    uuid.UUID
+	// FromListID is the list the entry was moved from by the change, empty if
+	// it stayed in ListID.
+	FromListID *uuid.UUID
+	// Action is created, updated, deleted or restored.
+	Action string
+	// Before and After are the entry as JSON before and after the change,
+	// empty for created and deleted entries respectively.
+	Before *string `gorm:"column:before_state"`
+	After  *string `gorm:"column:after_state"`
+	// UserID is empty for anonymous users, which may have access through the
+	// share ShareID.
+	UserID   *uuid.UUID
+	ShareID  *uuid.UUID
+	ClientIP string
+}
+
+const (
+	// OperationClearBought deletes all bought entries of a list.
+	OperationClearBought = "clear-bought"
+	// OperationUncheckAll marks all bought entries of a list as not bought.
+	OperationUncheckAll = "uncheck-all"
+)
+
+// ListOperation is a change of many entries of a list at once. The affected
+// entries are recorded, so the change can be undone.
+type ListOperation struct {
+	Model
+
+	ListID uuid.UUID
+	// UserID is empty for anonymous users.
+	UserID   *uuid.UUID
+	Kind     string
+	EntryIDs []uuid.UUID `gorm:"serializer:json"`
+	UndoneAt *time.Time
+}
+
+// ListEvent is an event in the event log of a list. The log lets clients
+// catch up on the events they missed while they were disconnected.
+type ListEvent struct {
+	// ID orders the events of all lists.
+	ID        int64 `gorm:"primaryKey"`
+	ListID    uuid.UUID
+	Seq       int64
+	CreatedAt time.Time
+	// Version, Type, ActorID and Payload are the fields of the event envelope.
+	Version int
+	Type    string
+	ActorID *uuid.UUID
+	Payload string
+}
+
 type Entry struct {
 	Model
 
 	Name   string
 	Number string
+	// Amount and Unit are the quantity parsed from Number, empty if Number is
+	// not a quantity.
+	Amount *float64
+	Unit   quantity.Unit
 
 	Bought bool
 
@@ -47,6 +245,10 @@ type Entry struct {
 	Type   Type `json:"-"`
 
 	ListID uuid.UUID
+
+	// Version is increased on every change, so concurrent changes can be
+	// detected.
+	Version int64 `gorm:"default:1"`
 }
 
 func (e *Entry) BeforeCreate(tx *gorm.DB) error {
@@ -62,10 +264,20 @@ func (e *Entry) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// SetNumber sets the number of the entry and the quantity parsed from it.
+func (e *Entry) SetNumber(n string) {
+	e.Number = n
+	e.Amount, e.Unit = nil, ""
+	if q, err := quantity.Parse(n); err == nil {
+		e.Amount, e.Unit = &q.Amount, q.Unit
+	}
+}
+
 type Type struct {
 	Model
 
-	Name     string
-	Color    string
-	Priority int
+	Name      string
+	Immutable bool
+	Color     string
+	Priority  int
 }
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HashPassword hashes the password to be stored in User.PasswordHash.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("unable to hash password, %w", err)
+	}
+	return string(hash), nil
+}
+
+// CheckPassword returns an error, if the password does not match the hash of the user.
+func (u User) CheckPassword(password string) error {
+	return CompareHashAndPassword(u.PasswordHash, password)
+}
+
+// CompareHashAndPassword returns an error, if the password does not match a
+// hash created by HashPassword.
+func CompareHashAndPassword(hash, password string) error {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
+
+// dummyHash is used to spend the same time on unknown users as on known ones.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+// SimulatePasswordCheck takes as long as CheckPassword, so the response time
+// does not leak whether a user exists.
+func SimulatePasswordCheck(password string) {
+	_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
@@ -1,7 +1,57 @@
-import { type Entry, type List, type User, type Type } from "@/types.ts";
+import {
+  type Entry,
+  type List,
+  type ListOperation,
+  type MergeResult,
+  type ShareCreated,
+  type User,
+  type Type,
+} from "@/types.ts";
 
-export async function apiFetchJSON(url: string, options = {}) {
-  const response = await fetch(url, options);
+// csrfToken returns the token from the CSRF cookie. Browsers without support
+// for the Sec-Fetch-Site header need to send it back in a header.
+function csrfToken(): string {
+  const match = document.cookie.match(/(?:^|;\s*)_csrf=([^;]*)/);
+  return match ? decodeURIComponent(match[1]) : "";
+}
+
+async function apiFetch(url: string, options: RequestInit = {}) {
+  const headers = new Headers(options.headers);
+  headers.set("X-CSRF-Token", csrfToken());
+  return fetch(url, { ...options, headers });
+}
+
+export async function apiFetchJSON(url: string, options: RequestInit = {}) {
+  const response = await apiFetch(url, options);
+  if (!response.ok) {
+    throw new Error(`API Error, ${response.status}`);
+  }
+  return response.json();
+}
+
+// EntryConflictError is thrown, if an entry was changed by someone else since
+// it was read. It contains the current entry.
+export class EntryConflictError extends Error {
+  current: Entry;
+
+  constructor(current: Entry) {
+    super("Entry was changed in the meantime");
+    this.current = current;
+  }
+}
+
+// ifMatch returns the If-Match header for the version of the entry.
+function ifMatch(entry: Entry): Record<string, string> {
+  return entry.Version ? { "If-Match": `"${entry.Version}"` } : {};
+}
+
+// apiFetchEntry is apiFetchJSON for changes of an entry, which throws an
+// EntryConflictError, if the entry was changed in the meantime.
+async function apiFetchEntry(url: string, options: RequestInit) {
+  const response = await apiFetch(url, options);
+  if (response.status === 409 || response.status === 412) {
+    throw new EntryConflictError((await response.json()) as Entry);
+  }
   if (!response.ok) {
     throw new Error(`API Error, ${response.status}`);
   }
@@ -47,7 +97,7 @@ export async function apiGetEntry(entryID: string): Promise<Entry> {
 }
 
 export async function apiUpdateEntry(entry: Entry): Promise<Entry> {
-  const json = await apiFetchJSON(`/api/v1/entries/${entry.ID}`, {
+  const json = await apiFetchEntry(`/api/v1/entries/${entry.ID}`, {
     method: "PUT",
     body: JSON.stringify({
       Name: entry.Name,
@@ -58,28 +108,143 @@ export async function apiUpdateEntry(entry: Entry): Promise<Entry> {
     }),
     headers: {
       "Content-Type": "application/json",
+      ...ifMatch(entry),
+    },
+  });
+  return json as Entry;
+}
+
+// apiPatchEntry changes only the fields of the entry given in patch.
+export async function apiPatchEntry(
+  entry: Entry,
+  patch: Partial<Entry>,
+): Promise<Entry> {
+  const json = await apiFetchEntry(`/api/v1/entries/${entry.ID}`, {
+    method: "PATCH",
+    body: JSON.stringify(patch),
+    headers: {
+      "Content-Type": "application/merge-patch+json",
+      ...ifMatch(entry),
     },
   });
   return json as Entry;
 }
 
 export async function apiDeleteEntry(entry: Entry): Promise<Entry> {
-  const json = await apiFetchJSON(`/api/v1/entries/${entry.ID}`, {
+  const json = await apiFetchEntry(`/api/v1/entries/${entry.ID}`, {
     method: "DELETE",
     headers: {
       "Content-Type": "application/json",
+      ...ifMatch(entry),
     },
   });
   return json as Entry;
 }
 
+// apiListOperation runs clear-bought or uncheck-all on the list.
+export async function apiListOperation(
+  listID: string,
+  kind: "clear-bought" | "uncheck-all",
+): Promise<ListOperation> {
+  const json = await apiFetchJSON(`/api/v1/lists/${listID}/${kind}`, {
+    method: "POST",
+  });
+  return json as ListOperation;
+}
+
+export async function apiUndoListOperation(
+  operation: ListOperation,
+): Promise<ListOperation> {
+  const json = await apiFetchJSON(
+    `/api/v1/lists/${operation.ListID}/operations/${operation.ID}/undo`,
+    { method: "POST" },
+  );
+  return json as ListOperation;
+}
+
+// apiMergeDuplicates merges the entries of the list with the same name and
+// compatible quantities.
+export async function apiMergeDuplicates(
+  listID: string,
+): Promise<MergeResult> {
+  const json = await apiFetchJSON(`/api/v1/lists/${listID}/merge-duplicates`, {
+    method: "POST",
+  });
+  return json as MergeResult;
+}
+
+// apiRestoreEntry restores a deleted entry.
+export async function apiRestoreEntry(entry: Entry): Promise<Entry> {
+  const json = await apiFetchJSON(`/api/v1/entries/${entry.ID}/restore`, {
+    method: "POST",
+  });
+  return json as Entry;
+}
+
+// apiCreateShare creates a share link for the list. Only the owner may share
+// a list.
+export async function apiCreateShare(
+  listID: string,
+  role: string,
+  expiresAt: Date | null,
+  maxUses: number,
+): Promise<ShareCreated> {
+  const response = await apiFetch(`/api/v1/lists/${listID}/shares`, {
+    method: "POST",
+    body: JSON.stringify({
+      Role: role,
+      ExpiresAt: expiresAt,
+      MaxUses: maxUses,
+    }),
+    headers: {
+      "Content-Type": "application/json",
+    },
+  });
+  if (response.status === 403) {
+    throw new Error("Only the owner can share the list");
+  }
+  if (!response.ok) {
+    throw new Error(`API Error, ${response.status}`);
+  }
+  return (await response.json()) as ShareCreated;
+}
+
+// apiRedeemShare remembers the share of the token in the session and returns
+// the shared list.
+export async function apiRedeemShare(
+  token: string,
+): Promise<{ ListID: string; Role: string }> {
+  const response = await apiFetch("/api/v1/shares/redeem", {
+    method: "POST",
+    body: JSON.stringify({ Token: token }),
+    headers: {
+      "Content-Type": "application/json",
+    },
+  });
+  if (response.status === 404) {
+    throw new Error("The share link is unknown or was revoked");
+  }
+  if (response.status === 410) {
+    throw new Error("The share link is expired or used up");
+  }
+  if (!response.ok) {
+    throw new Error(`API Error, ${response.status}`);
+  }
+  return response.json();
+}
+
 export async function apiGetTypes(): Promise<Type[]> {
   const json = await apiFetchJSON(`/api/v1/types`);
   return json as Type[];
 }
 
-export async function apiCreateSession(username: string, password: string) {
-  const response = await fetch("api/v1/session", {
+// apiCreateSession logs the user in. It returns true, if the user has TOTP
+// enabled and the login has to be completed with apiVerifySession.
+export async function apiCreateSession(
+  username: string,
+  password: string,
+): Promise<boolean> {
+  const response = await apiFetch("api/v1/session", {
     method: "POST",
     body: JSON.stringify({
       name: username,
@@ -92,6 +257,28 @@ export async function apiCreateSession(username: string, password: string) {
   if (!response.ok) {
     throw new Error(`API Error, ${response.status}`);
   }
+  return response.status === 202;
+}
+
+// apiVerifySession completes the login of a user with TOTP enabled with
+// either the current code of the authenticator app or a recovery code.
+export async function apiVerifySession(code: string, recoveryCode: string) {
+  const response = await apiFetch("api/v1/session/verify", {
+    method: "POST",
+    body: JSON.stringify({ Code: code, RecoveryCode: recoveryCode }),
+    headers: {
+      "Content-Type": "application/json",
+    },
+  });
+  if (response.status === 401) {
+    throw new Error("Invalid code or the login took too long");
+  }
+  if (response.status === 429) {
+    throw new Error("Too many failed logins, please wait a moment");
+  }
+  if (!response.ok) {
+    throw new Error(`API Error, ${response.status}`);
+  }
 }
 
 export async function apiGetSession(): Promise<User> {
@@ -100,7 +287,7 @@ export async function apiGetSession(): Promise<User> {
 }
 
 export async function apiDeleteSession() {
-  const response = await fetch("api/v1/session", {
+  const response = await apiFetch("api/v1/session", {
     method: "DELETE",
   });
   if (!response.ok) {
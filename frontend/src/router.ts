@@ -4,12 +4,14 @@ import Home from "./Pages/Home.vue";
 import List from "./Pages/List.vue";
 import Login from "./Pages/Login.vue";
 import EntryEditor from "./Pages/EntryEditor.vue";
+import Share from "./Pages/Share.vue";
 
 const routes = [
   { path: "/", component: Home, name: "home" },
   { path: "/list/:id", component: List, name: "list" },
   { path: "/entry/:id/edit", component: EntryEditor, name: "entryEditor" },
   { path: "/login", component: Login, name: "login" },
+  { path: "/share/:token", component: Share, name: "share" },
 ];
 
 export const router = createRouter({
@@ -1,5 +1,9 @@
 export interface List {
   ID: string;
+  Name: string;
+  Description: string;
+  Archived: boolean;
+  OwnerID: string | null;
 }
 
 export interface Type {
@@ -15,14 +19,87 @@ export interface Entry {
   Name: string;
   Bought: boolean;
   Number: string;
+  // Amount and Unit are parsed from Number, null and empty if it is no
+  // quantity.
+  Amount: number | null;
+  Unit: string;
   ListID: string;
   TypeID: string;
+  Version: number;
 }
 
 export interface User {
   ID: string;
   Name: string;
   IsAdmin: boolean;
+  Disabled: boolean;
+}
+
+// ListOperation changed many entries of a list at once and can be undone.
+export interface ListOperation {
+  ID: string;
+  ListID: string;
+  Kind: string;
+  EntryIDs: string[];
+  UndoneAt: string | null;
+}
+
+// MergeResult lists the entries duplicates were merged into and the deleted
+// duplicates.
+export interface MergeResult {
+  Merged: Entry[];
+  Deleted: string[];
+}
+
+// Watcher is another client viewing the same list.
+export interface Watcher {
+  ID: string;
+  UserID: string | null;
+  UserName: string;
+  ShareID: string | null;
+  Role: string;
+  Transport: string;
+  Since: string;
+}
+
+// Envelope is an event streamed from the server, see api/v1/events.schema.json.
+export interface Envelope<T> {
+  Version: number;
+  Type: string;
+  ListID: string;
+  Seq?: number;
+  ActorID: string | null;
+  Time: string;
+  Payload?: T;
+}
+
+// BatchItem is an entry event of a batch.
+export interface BatchItem {
+  Type: string;
+  Payload: Entry;
+}
+
+// ListShare grants access to a list without a login.
+export interface ListShare {
+  ID: string;
+  ListID: string;
+  Role: string;
+  ExpiresAt: string | null;
+  // MaxUses is 0 for unlimited uses.
+  MaxUses: number;
+  Uses: number;
+}
+
+// ShareCreated is a new share with its token, which is only available once.
+export interface ShareCreated extends ListShare {
+  Token: string;
+}
+
+// Member is a user with access to a list.
+export interface Member {
+  UserID: string;
+  Name: string;
+  Role: string;
 }
 
 export type ContextmenuAction = {
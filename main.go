@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/shaardie/listinator/api/v1/server"
@@ -19,9 +26,14 @@ import (
 //go:embed frontend/dist/*
 var frontendFS embed.FS
 
+// shutdownTimeout is the time in-flight requests get to finish on shutdown.
+const shutdownTimeout = 5 * time.Second
+
 func main() {
 	p := os.Getenv("LISTINATOR_DATABASE_DIR")
-	dbPath := path.Join(p, "listinator.db")
+	// Wait for locks and take the write lock at the start of transactions, so
+	// multiple instances can share the database.
+	dbPath := path.Join(p, "listinator.db") + "?_busy_timeout=5000&_txlock=immediate"
 
 	if err := logger.Init(); err != nil {
 		panic(err)
@@ -32,13 +44,38 @@ func main() {
 		panic("session secret missing")
 	}
 
+	sessionIdleTimeout := 30 * 24 * time.Hour
+	if v := os.Getenv("LISTINATOR_SESSION_IDLE_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			panic(fmt.Errorf("unable to parse session idle timeout, %w", err))
+		}
+		sessionIdleTimeout = d
+	}
+
+	trashRetention := 30 * 24 * time.Hour
+	if v := os.Getenv("LISTINATOR_TRASH_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			panic(fmt.Errorf("unable to parse trash retention, %w", err))
+		}
+		trashRetention = d
+	}
+
 	// init database
 	db, err := database.Init(dbPath)
 	if err != nil {
 		panic(err)
 	}
 
+	// Trust X-Forwarded-For only from the configured reverse proxies
+	ipExtractor, err := server.IPExtractor(os.Getenv("LISTINATOR_TRUSTED_PROXIES"))
+	if err != nil {
+		panic(err)
+	}
+
 	e := echo.New()
+	e.IPExtractor = ipExtractor
 	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
 		LogStatus:   true,
 		LogURI:      true,
@@ -61,14 +98,52 @@ func main() {
 		},
 	}))
 	e.Use(session.Middleware(sessions.NewCookieStore([]byte(sessionSecret))))
+	e.Use(server.CSRFMiddleware())
 
 	// API V1
 	apiV1 := e.Group("/api/v1")
-	sV1 := server.New(db)
+	sV1, err := server.New(db, server.Config{
+		SessionIdleTimeout: sessionIdleTimeout,
+		PubSub:             os.Getenv("LISTINATOR_PUBSUB"),
+		SlowConsumer:       os.Getenv("LISTINATOR_PUBSUB_SLOW_CONSUMER"),
+		TrashRetention:     trashRetention,
+	})
+	if err != nil {
+		panic(err)
+	}
 	sV1.SetupRoutes(apiV1)
 
 	// Embeded Frontend
 	e.StaticFS("/", echo.MustSubFS(frontendFS, "frontend/dist"))
 
-	e.Logger.Fatal(e.Start("0.0.0.0:8080"))
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		if err := e.Start("0.0.0.0:8080"); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
+	<-ctx.Done()
+
+	slog.Info("shutting down")
+	// End the event streams first, they would keep the shutdown waiting
+	sV1.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		slog.Error("unable to shut down http server gracefully", "error", err)
+	}
+	// The WebSocket connections are hijacked and not waited for above
+	if err := sV1.Wait(ctx); err != nil {
+		slog.Error("unable to wait for the websocket connections", "error", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		slog.Error("unable to get database connection", "error", err)
+		return
+	}
+	if err := sqlDB.Close(); err != nil {
+		slog.Error("unable to close database", "error", err)
+	}
 }
@@ -0,0 +1,96 @@
+package pubsub
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message is a message published for the key Key.
+type Message[K comparable, T any] struct {
+	Key   K
+	Value T
+}
+
+// Source returns the messages stored after the cursor in the order they were
+// published and the cursor of the last returned message.
+type Source[K comparable, T any] func(cursor int64) ([]Message[K, T], int64, error)
+
+// Polling is a PubSub for multiple processes sharing a store like a database.
+// The publishers write the messages to the store themselves, Polling fetches
+// them periodically from the Source and delivers them to the subscribers of
+// this process.
+type Polling[K comparable, T any] struct {
+	local    *Memory[K, T]
+	source   Source[K, T]
+	interval time.Duration
+	notify   chan struct{}
+	done     chan struct{}
+}
+
+// NewPolling creates a Polling, which delivers all messages after cursor and
+// polls the source every interval.
+func NewPolling[K comparable, T any](source Source[K, T], cursor int64, interval time.Duration, opts Options[T]) *Polling[K, T] {
+	ps := &Polling[K, T]{
+		local:    NewMemory[K, T](opts),
+		source:   source,
+		interval: interval,
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go ps.run(cursor)
+	return ps
+}
+
+func (ps *Polling[K, T]) run(cursor int64) {
+	ticker := time.NewTicker(ps.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ps.done:
+			return
+		case <-ticker.C:
+		case <-ps.notify:
+		}
+
+		msgs, next, err := ps.source(cursor)
+		if err != nil {
+			slog.Error("unable to poll messages", "error", err)
+			continue
+		}
+		cursor = next
+		for _, msg := range msgs {
+			ps.local.Publish(msg.Key, msg.Value)
+		}
+	}
+}
+
+// Publish does not deliver t, because the publisher already wrote it to the
+// store. It only polls the store right away, so subscribers of this process
+// do not have to wait for the next interval.
+func (ps *Polling[K, T]) Publish(k K, t T) error {
+	select {
+	case ps.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (ps *Polling[K, T]) Subscribe(k K) (uuid.UUID, chan T, error) {
+	return ps.local.Subscribe(k)
+}
+
+func (ps *Polling[K, T]) Unsubscribe(k K, id uuid.UUID) {
+	ps.local.Unsubscribe(k, id)
+}
+
+// Stats returns the counters of this process.
+func (ps *Polling[K, T]) Stats() Stats[K] {
+	return ps.local.Stats()
+}
+
+// Close stops polling.
+func (ps *Polling[K, T]) Close() {
+	close(ps.done)
+}
@@ -5,110 +5,340 @@ import (
 	"fmt"
 	"log/slog"
 	"sync"
-	"time"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 )
 
-type PubSub[K comparable, T any] struct {
-	buffer int
-	m      *sync.Mutex
-	store  map[K]map[uuid.UUID]chan T
+// PubSub delivers messages published for a key to all subscribers of the key.
+type PubSub[K comparable, T any] interface {
+	// Publish sends t to all subscribers of k.
+	Publish(k K, t T) error
+	// Subscribe returns a channel receiving the messages for k. The channel
+	// is closed, if the subscriber is disconnected.
+	Subscribe(k K) (uuid.UUID, chan T, error)
+	// Unsubscribe removes the subscriber id of k and closes its channel.
+	Unsubscribe(k K, id uuid.UUID)
+	// Stats returns the current counters.
+	Stats() Stats[K]
 }
 
-func New[K comparable, T any](buffer int) PubSub[K, T] {
-	return PubSub[K, T]{
-		m:      &sync.Mutex{},
-		store:  map[K]map[uuid.UUID]chan T{},
-		buffer: buffer,
-	}
+// Stats contains counters about the delivery of the messages.
+type Stats[K comparable] struct {
+	// Subscribers is the number of subscribers per key.
+	Subscribers map[K]int
+	// Published is the number of published messages.
+	Published uint64
+	// Delivered is the number of messages received by subscribers.
+	Delivered uint64
+	// Dropped is the number of messages dropped or merged into others,
+	// because subscribers could not keep up.
+	Dropped uint64
+	// Disconnected is the number of subscribers disconnected, because they
+	// could not keep up.
+	Disconnected uint64
 }
 
-// getOrInit gets or initializes the store part for the Key k.
-// This is for internal use and has therefor the Mutex not locked, so use with care.
-func (ps PubSub[K, T]) getOrInit(k K) map[uuid.UUID]chan T {
-	subs, ok := ps.store[k]
+// counters are the counters of Stats shared with the subscribers.
+type counters struct {
+	published    atomic.Uint64
+	delivered    atomic.Uint64
+	dropped      atomic.Uint64
+	disconnected atomic.Uint64
+}
 
-	// Key is not present, so init it.
-	if !ok {
-		ps.store[k] = map[uuid.UUID]chan T{}
-		subs = ps.store[k]
+// Policy decides what happens, if the queue of a subscriber is full.
+type Policy int
+
+const (
+	// Disconnect closes the channel of the subscriber, so it can reconnect
+	// and catch up on its own.
+	Disconnect Policy = iota
+	// DropOldest drops the oldest queued message.
+	DropOldest
+	// Coalesce merges the message with the last queued one using
+	// Options.Merge, so the order of the messages is kept. If it can not be
+	// merged, the subscriber is disconnected.
+	Coalesce
+)
+
+func (p Policy) String() string {
+	switch p {
+	case Disconnect:
+		return "disconnect"
+	case DropOldest:
+		return "drop-oldest"
+	case Coalesce:
+		return "coalesce"
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// ParsePolicy returns the Policy with the name s, see Policy.String.
+func ParsePolicy(s string) (Policy, error) {
+	for _, p := range []Policy{Disconnect, DropOldest, Coalesce} {
+		if p.String() == s {
+			return p, nil
+		}
 	}
+	return 0, fmt.Errorf("unknown policy %q", s)
+}
 
-	return subs
+// Options configures the delivery to the subscribers.
+type Options[T any] struct {
+	// Buffer is the number of messages queued per subscriber.
+	Buffer int
+	// Policy is applied, if the queue of a subscriber is full.
+	Policy Policy
+	// Merge merges the message t into the queued message and reports whether
+	// this was possible. Required for Coalesce.
+	Merge func(queued, t T) (T, bool)
 }
 
-func (ps PubSub[K, T]) Publish(k K, t T) error {
-	ps.m.Lock()
-	defer ps.m.Unlock()
+// subscriber queues the messages for one subscriber and delivers them in its
+// own goroutine, so a slow subscriber does not block the publisher.
+type subscriber[T any] struct {
+	ch       chan T
+	wake     chan struct{}
+	done     chan struct{}
+	counters *counters
 
-	subs := ps.getOrInit(k)
+	m      sync.Mutex
+	queue  []T
+	closed bool
+}
 
-	// Send to all subs parallel and with timeout
-	var wg sync.WaitGroup
-	var muStale sync.Mutex
-	stale := []uuid.UUID{}
-	wg.Add(len(subs))
-	for id, sub := range subs {
-		go func(id uuid.UUID, ch chan T) {
-			defer wg.Done()
+func newSubscriber[T any](buffer int, counters *counters) *subscriber[T] {
+	sub := &subscriber[T]{
+		ch:       make(chan T),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		counters: counters,
+		queue:    make([]T, 0, buffer),
+	}
+	go sub.run()
+	return sub
+}
+
+// run delivers the queued messages until the subscriber is closed.
+func (sub *subscriber[T]) run() {
+	defer close(sub.ch)
+	for {
+		sub.m.Lock()
+		if len(sub.queue) == 0 {
+			sub.m.Unlock()
 			select {
-			case ch <- t:
-			case <-time.After(100 * time.Millisecond):
-				slog.Error("subscriber too slow to keep up, disconnecting it", "sub", id)
-				muStale.Lock()
-				stale = append(stale, id)
-				muStale.Unlock()
+			case <-sub.wake:
+				continue
+			case <-sub.done:
+				return
 			}
-		}(id, sub)
+		}
+		t := sub.queue[0]
+		var zero T
+		sub.queue[0] = zero
+		sub.queue = sub.queue[1:]
+		sub.m.Unlock()
+
+		select {
+		case sub.ch <- t:
+			sub.counters.delivered.Add(1)
+		case <-sub.done:
+			return
+		}
 	}
-	wg.Wait()
+}
+
+// push queues t and reports whether the subscriber is still connected.
+func (sub *subscriber[T]) push(t T, opts Options[T]) bool {
+	sub.m.Lock()
+	defer sub.m.Unlock()
+	return sub.enqueue(t, opts)
+}
 
-	// A subscriber that could not keep up would otherwise silently miss this
-	// event forever. Disconnect it instead, so its SSE handler returns, the
-	// client's EventSource sees the closed connection and reconnects, and a
-	// full resync (see entryList) picks up whatever was missed.
-	for _, id := range stale {
-		close(subs[id])
-		delete(subs, id)
+// enqueue queues t and applies the policy, if the queue is full.
+// This is for internal use and expects the Mutex to be locked, so use with care.
+func (sub *subscriber[T]) enqueue(t T, opts Options[T]) bool {
+	if sub.closed {
+		return false
 	}
-	return nil
+
+	if len(sub.queue) >= opts.Buffer {
+		switch opts.Policy {
+		case DropOldest:
+			var zero T
+			sub.queue[0] = zero
+			sub.queue = sub.queue[1:]
+			sub.counters.dropped.Add(1)
+		case Coalesce:
+			// Merging with an earlier message would move t in front of the
+			// messages queued after it
+			last := len(sub.queue) - 1
+			m, ok := t, false
+			if opts.Merge != nil && last >= 0 {
+				m, ok = opts.Merge(sub.queue[last], t)
+			}
+			if !ok {
+				sub.close()
+				sub.counters.disconnected.Add(1)
+				return false
+			}
+			sub.queue[last] = m
+			sub.counters.dropped.Add(1)
+			return true
+		default:
+			sub.close()
+			sub.counters.disconnected.Add(1)
+			return false
+		}
+	}
+
+	sub.queue = append(sub.queue, t)
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+	return true
 }
 
-func (ps PubSub[K, T]) Subscribe(k K) (uuid.UUID, chan T, error) {
-	ps.m.Lock()
-	defer ps.m.Unlock()
+// close stops the delivery and closes the channel of the subscriber.
+// This is for internal use and expects the Mutex to be locked, so use with care.
+func (sub *subscriber[T]) close() {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	sub.queue = nil
+	close(sub.done)
+}
+
+// topic contains the subscribers of a key.
+type topic[T any] struct {
+	m    sync.RWMutex
+	subs map[uuid.UUID]*subscriber[T]
+}
+
+// Memory is a PubSub within a single process.
+type Memory[K comparable, T any] struct {
+	opts     Options[T]
+	m        sync.RWMutex
+	topics   map[K]*topic[T]
+	counters counters
+}
+
+func NewMemory[K comparable, T any](opts Options[T]) *Memory[K, T] {
+	return &Memory[K, T]{
+		opts:   opts,
+		topics: map[K]*topic[T]{},
+	}
+}
+
+// Publish queues t for all subscribers of k without waiting for them.
+func (ps *Memory[K, T]) Publish(k K, t T) error {
+	ps.counters.published.Add(1)
+
+	ps.m.RLock()
+	tp, ok := ps.topics[k]
+	ps.m.RUnlock()
+	// Nobody is interested
+	if !ok {
+		return nil
+	}
 
-	subs := ps.getOrInit(k)
+	stale := []uuid.UUID{}
+	tp.m.RLock()
+	for id, sub := range tp.subs {
+		if !sub.push(t, ps.opts) {
+			stale = append(stale, id)
+		}
+	}
+	tp.m.RUnlock()
+
+	// A subscriber that could not keep up would otherwise silently miss
+	// events forever. It is disconnected instead, so its SSE handler
+	// returns, the client's EventSource sees the closed connection and
+	// reconnects and replays the missed events.
+	if len(stale) > 0 {
+		tp.m.Lock()
+		for _, id := range stale {
+			if _, ok := tp.subs[id]; ok {
+				slog.Error("subscriber too slow to keep up, disconnecting it", "sub", id)
+				delete(tp.subs, id)
+			}
+		}
+		tp.m.Unlock()
+	}
+	return nil
+}
 
-	// add new sub to subs
+func (ps *Memory[K, T]) Subscribe(k K) (uuid.UUID, chan T, error) {
 	id, err := uuid.NewUUID()
 	if err != nil {
 		return id, nil, fmt.Errorf("unable to create uuid, %w", err)
 	}
-	subs[id] = make(chan T, ps.buffer)
+	sub := newSubscriber[T](ps.opts.Buffer, &ps.counters)
 
-	return id, subs[id], nil
+	ps.m.Lock()
+	defer ps.m.Unlock()
+
+	tp, ok := ps.topics[k]
+	// Key is not present, so init it.
+	if !ok {
+		tp = &topic[T]{subs: map[uuid.UUID]*subscriber[T]{}}
+		ps.topics[k] = tp
+	}
+	tp.m.Lock()
+	tp.subs[id] = sub
+	tp.m.Unlock()
+
+	return id, sub.ch, nil
 }
 
-func (ps PubSub[K, T]) Unsubscribe(k K, id uuid.UUID) {
+func (ps *Memory[K, T]) Unsubscribe(k K, id uuid.UUID) {
 	ps.m.Lock()
 	defer ps.m.Unlock()
 
-	subs, ok := ps.store[k]
-
+	tp, ok := ps.topics[k]
 	// Key is not present, so there is nothing to unsubscribe
 	if !ok {
 		return
 	}
 
+	tp.m.Lock()
+	defer tp.m.Unlock()
+
 	// Publish may already have disconnected this subscriber (slow consumer),
-	// in which case it is no longer in subs. Closing a nil/missing channel
-	// again would panic, so only close it if it is still there.
-	ch, ok := subs[id]
-	if !ok {
-		return
+	// in which case it is no longer in subs.
+	if sub, ok := tp.subs[id]; ok {
+		sub.m.Lock()
+		sub.close()
+		sub.m.Unlock()
+		delete(tp.subs, id)
+	}
+
+	// Forget the key with the last subscriber
+	if len(tp.subs) == 0 {
+		delete(ps.topics, k)
+	}
+}
+
+func (ps *Memory[K, T]) Stats() Stats[K] {
+	ps.m.RLock()
+	defer ps.m.RUnlock()
+
+	subs := make(map[K]int, len(ps.topics))
+	for k, tp := range ps.topics {
+		tp.m.RLock()
+		if n := len(tp.subs); n > 0 {
+			subs[k] = n
+		}
+		tp.m.RUnlock()
+	}
+	return Stats[K]{
+		Subscribers:  subs,
+		Published:    ps.counters.published.Load(),
+		Delivered:    ps.counters.delivered.Load(),
+		Dropped:      ps.counters.dropped.Load(),
+		Disconnected: ps.counters.disconnected.Load(),
 	}
-	close(ch)
-	delete(subs, id)
 }
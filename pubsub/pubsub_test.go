@@ -0,0 +1,270 @@
+package pubsub
+
+import (
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// receive returns the next message from ch or fails after a second.
+func receive[T any](t *testing.T, ch chan T) (T, bool) {
+	t.Helper()
+	select {
+	case v, ok := <-ch:
+		return v, ok
+	case <-time.After(time.Second):
+		t.Fatal("timeout while waiting for message")
+	}
+	var zero T
+	return zero, false
+}
+
+func TestMemoryDelivery(t *testing.T) {
+	ps := NewMemory[string, int](Options[int]{Buffer: 4})
+	_, a, err := ps.Subscribe("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, b, err := ps.Subscribe("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 3 {
+		ps.Publish("a", i)
+	}
+	ps.Publish("b", 42)
+
+	for want := range 3 {
+		if got, _ := receive(t, a); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if got, _ := receive(t, b); got != 42 {
+		t.Errorf("got %v, want 42", got)
+	}
+}
+
+func TestMemoryUnsubscribe(t *testing.T) {
+	ps := NewMemory[string, int](Options[int]{Buffer: 4})
+	id, ch, err := ps.Subscribe("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.Unsubscribe("a", id)
+	if _, ok := receive(t, ch); ok {
+		t.Error("channel not closed")
+	}
+	if len(ps.topics) != 0 {
+		t.Errorf("key not forgotten")
+	}
+	// unsubscribing twice is fine
+	ps.Unsubscribe("a", id)
+}
+
+func TestMemoryPolicies(t *testing.T) {
+	// merge adds up the messages with the same parity
+	merge := func(queued, n int) (int, bool) {
+		if queued%2 != n%2 {
+			return 0, false
+		}
+		return queued + n, true
+	}
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		publish []int
+		// want are the messages received, before the channel is closed
+		want []int
+		// closed tells whether the subscriber got disconnected
+		closed bool
+	}{
+		{
+			name:    "disconnect",
+			policy:  Disconnect,
+			publish: []int{1, 2, 3},
+			closed:  true,
+		},
+		{
+			name:    "drop oldest",
+			policy:  DropOldest,
+			publish: []int{1, 2, 3, 4},
+			want:    []int{3, 4},
+		},
+		{
+			name:    "coalesce",
+			policy:  Coalesce,
+			publish: []int{1, 2, 4, 6},
+			want:    []int{1, 12},
+		},
+		{
+			name:    "coalesce without match",
+			policy:  Coalesce,
+			publish: []int{1, 3, 2},
+			closed:  true,
+		},
+		{
+			// 3 matches 1, but merging would move it in front of 2
+			name:    "coalesce interleaved",
+			policy:  Coalesce,
+			publish: []int{1, 2, 3},
+			closed:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := NewMemory[string, int](Options[int]{Buffer: 2, Policy: tt.policy, Merge: merge})
+			id, ch, err := ps.Subscribe("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Hold the lock of the subscriber, so nothing is delivered
+			// before all messages are queued
+			sub := ps.topics["a"].subs[id]
+			sub.m.Lock()
+			for _, n := range tt.publish {
+				if !sub.enqueue(n, ps.opts) {
+					break
+				}
+			}
+			sub.m.Unlock()
+
+			got := []int{}
+			for range tt.want {
+				n, ok := receive(t, ch)
+				if !ok {
+					break
+				}
+				got = append(got, n)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if tt.closed {
+				if _, ok := receive(t, ch); ok {
+					t.Error("subscriber not disconnected")
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStats(t *testing.T) {
+	ps := NewMemory[string, int](Options[int]{Buffer: 1, Policy: DropOldest})
+	_, a, err := ps.Subscribe("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ps.Subscribe("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	ps.Publish("a", 1)
+	receive(t, a)
+	ps.Publish("b", 2)
+
+	want := Stats[string]{
+		Subscribers: map[string]int{"a": 2},
+		Published:   2,
+		Delivered:   1,
+	}
+	// the delivery is counted right after the message was received
+	got := ps.Stats()
+	for deadline := time.Now().Add(time.Second); got.Delivered == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+		got = ps.Stats()
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// BenchmarkPublish publishes to thousands of subscribers of a single key,
+// which receive all messages.
+func BenchmarkPublish(b *testing.B) {
+	for _, n := range []int{100, 1000, 5000} {
+		b.Run(fmt.Sprintf("subscribers=%d", n), func(b *testing.B) {
+			ps := NewMemory[string, int](Options[int]{Buffer: 16, Policy: DropOldest})
+			for range n {
+				id, ch, err := ps.Subscribe("a")
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer ps.Unsubscribe("a", id)
+				go func() {
+					for range ch {
+					}
+				}()
+			}
+
+			b.ResetTimer()
+			for i := range b.N {
+				ps.Publish("a", i)
+			}
+			b.StopTimer()
+			// messages queued for the subscribers per second
+			b.ReportMetric(float64(b.N*n)/b.Elapsed().Seconds(), "msgs/s")
+		})
+	}
+}
+
+// BenchmarkPublishSlowSubscribers shows, that subscribers which never receive
+// do not slow down the publisher.
+func BenchmarkPublishSlowSubscribers(b *testing.B) {
+	// do not log every disconnected subscriber
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+
+	for _, policy := range []Policy{Disconnect, DropOldest, Coalesce} {
+		b.Run(policy.String(), func(b *testing.B) {
+			ps := NewMemory[string, int](Options[int]{
+				Buffer: 16,
+				Policy: policy,
+				Merge:  func(_, n int) (int, bool) { return n, true },
+			})
+			for range 1000 {
+				id, _, err := ps.Subscribe("a")
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer ps.Unsubscribe("a", id)
+			}
+
+			b.ResetTimer()
+			for i := range b.N {
+				ps.Publish("a", i)
+			}
+		})
+	}
+}
+
+// BenchmarkPublishParallel publishes to many keys in parallel, which do not
+// share a lock.
+func BenchmarkPublishParallel(b *testing.B) {
+	ps := NewMemory[int, int](Options[int]{Buffer: 16, Policy: DropOldest})
+	const keys = 100
+	for k := range keys {
+		for range 10 {
+			id, ch, err := ps.Subscribe(k)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer ps.Unsubscribe(k, id)
+			go func() {
+				for range ch {
+				}
+			}()
+		}
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			ps.Publish(i%keys, i)
+			i++
+		}
+	})
+}
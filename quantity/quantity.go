@@ -0,0 +1,178 @@
+// Package quantity parses free-form quantities like "2x", "500 g" or "0,5kg"
+// into an amount of a normalized unit, so they can be summed and compared.
+package quantity
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Unit is a normalized unit. Weights are normalized to grams and volumes to
+// milliliters.
+type Unit string
+
+const (
+	Piece      Unit = "piece"
+	Gram       Unit = "g"
+	Milliliter Unit = "ml"
+	Pack       Unit = "pack"
+	Bottle     Unit = "bottle"
+	Can        Unit = "can"
+)
+
+var (
+	// ErrInvalid is returned for text, which is not a quantity.
+	ErrInvalid = errors.New("invalid quantity")
+	// ErrIncompatible is returned for adding quantities of different units.
+	ErrIncompatible = errors.New("incompatible units")
+)
+
+// unit is a unit as written together with its factor to the normalized unit.
+type unit struct {
+	unit   Unit
+	factor float64
+}
+
+// units are the known spellings of the units in lower case.
+var units = map[string]unit{
+	"":       {Piece, 1},
+	"x":      {Piece, 1},
+	"pc":     {Piece, 1},
+	"pcs":    {Piece, 1},
+	"piece":  {Piece, 1},
+	"pieces": {Piece, 1},
+	"stk":    {Piece, 1},
+	"stück":  {Piece, 1},
+
+	"mg":        {Gram, 0.001},
+	"g":         {Gram, 1},
+	"gr":        {Gram, 1},
+	"gram":      {Gram, 1},
+	"grams":     {Gram, 1},
+	"kg":        {Gram, 1000},
+	"kilo":      {Gram, 1000},
+	"kilos":     {Gram, 1000},
+	"kilogram":  {Gram, 1000},
+	"kilograms": {Gram, 1000},
+
+	"ml":     {Milliliter, 1},
+	"cl":     {Milliliter, 10},
+	"dl":     {Milliliter, 100},
+	"l":      {Milliliter, 1000},
+	"liter":  {Milliliter, 1000},
+	"liters": {Milliliter, 1000},
+	"litre":  {Milliliter, 1000},
+	"litres": {Milliliter, 1000},
+
+	"pack":     {Pack, 1},
+	"packs":    {Pack, 1},
+	"pk":       {Pack, 1},
+	"pkg":      {Pack, 1},
+	"package":  {Pack, 1},
+	"packages": {Pack, 1},
+
+	"bottle":  {Bottle, 1},
+	"bottles": {Bottle, 1},
+
+	"can":  {Can, 1},
+	"cans": {Can, 1},
+}
+
+// pattern matches an amount with an optional fraction, unit and multiplication
+// sign in front, e.g. "2x", "x2", "1/2 l" or "0.5kg".
+var pattern = regexp.MustCompile(`^(?:x\s*)?(\d+(?:\.\d+)?|\.\d+)(?:\s*/\s*(\d+))?\s*(\pL*)\.?$`)
+
+// Quantity is an amount of a normalized unit.
+type Quantity struct {
+	Amount float64
+	Unit   Unit
+}
+
+// Parse parses the text s into a quantity. A number without unit is a number
+// of pieces. Decimal commas and "×" for "x" are accepted.
+func Parse(s string) (Quantity, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.ReplaceAll(s, ",", ".")
+	s = strings.ReplaceAll(s, "×", "x")
+	m := pattern.FindStringSubmatch(s)
+	if m == nil {
+		return Quantity{}, fmt.Errorf("%w %q", ErrInvalid, s)
+	}
+
+	amount, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return Quantity{}, fmt.Errorf("%w %q, %w", ErrInvalid, s, err)
+	}
+	if m[2] != "" {
+		d, err := strconv.ParseFloat(m[2], 64)
+		if err != nil || d == 0 {
+			return Quantity{}, fmt.Errorf("%w %q", ErrInvalid, s)
+		}
+		amount /= d
+	}
+	if amount <= 0 {
+		return Quantity{}, fmt.Errorf("%w %q", ErrInvalid, s)
+	}
+
+	u, ok := units[m[3]]
+	if !ok {
+		return Quantity{}, fmt.Errorf("%w %q, unknown unit %q", ErrInvalid, s, m[3])
+	}
+	return Quantity{Amount: round(amount * u.factor), Unit: u.unit}, nil
+}
+
+// Add returns the sum of q and o, which must have the same unit.
+func (q Quantity) Add(o Quantity) (Quantity, error) {
+	if q.Unit != o.Unit {
+		return Quantity{}, fmt.Errorf("%w %q and %q", ErrIncompatible, q.Unit, o.Unit)
+	}
+	return Quantity{Amount: round(q.Amount + o.Amount), Unit: q.Unit}, nil
+}
+
+// String formats the quantity in a way Parse understands. Weights and volumes
+// of a thousand or more are written in kg and l, weights below a gram in mg
+// and pieces without unit.
+func (q Quantity) String() string {
+	amount, u := q.Amount, string(q.Unit)
+	switch q.Unit {
+	case Piece:
+		return format(amount)
+	case Gram:
+		if amount >= 1000 {
+			amount, u = amount/1000, "kg"
+		} else if amount < 1 {
+			amount, u = amount*1000, "mg"
+		}
+	case Milliliter:
+		if amount >= 1000 {
+			amount, u = amount/1000, "l"
+		}
+	case Pack, Bottle, Can:
+		if amount != 1 {
+			u += "s"
+		}
+	}
+	return format(amount) + " " + u
+}
+
+// significantDigits is the precision amounts are rounded to. It keeps small
+// amounts like 0.1 mg, which are fractions of the normalized unit.
+const significantDigits = 12
+
+// round rounds to significantDigits to hide the errors of floating point
+// arithmetic.
+func round(f float64) float64 {
+	if f == 0 {
+		return 0
+	}
+	scale := math.Pow(10, significantDigits-math.Ceil(math.Log10(math.Abs(f))))
+	return math.Round(f*scale) / scale
+}
+
+func format(f float64) string {
+	return strconv.FormatFloat(round(f), 'f', -1, 64)
+}
@@ -0,0 +1,99 @@
+package quantity
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Quantity
+	}{
+		{"2", Quantity{2, Piece}},
+		{"2x", Quantity{2, Piece}},
+		{"x2", Quantity{2, Piece}},
+		{"2×", Quantity{2, Piece}},
+		{"× 2", Quantity{2, Piece}},
+		{"3 Stk.", Quantity{3, Piece}},
+		{"500 g", Quantity{500, Gram}},
+		{"0.5kg", Quantity{500, Gram}},
+		{"0,5 KG", Quantity{500, Gram}},
+		{"1/2 l", Quantity{500, Milliliter}},
+		{"33 cl", Quantity{330, Milliliter}},
+		{".25l", Quantity{250, Milliliter}},
+		{"2 packs", Quantity{2, Pack}},
+		{"1 bottle", Quantity{1, Bottle}},
+		{"0.1 mg", Quantity{0.0001, Gram}},
+		{"250 mg", Quantity{0.25, Gram}},
+		{"1/3 l", Quantity{333.333333333, Milliliter}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.s)
+		if err != nil {
+			t.Errorf("Parse(%q) failed, %v", tt.s, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "some", "kg", "0", "2 boxes", "1/0 l", "1-2"} {
+		if q, err := Parse(s); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Parse(%q) = %v, %v, want ErrInvalid", s, q, err)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	a, _ := Parse("1 l")
+	b, _ := Parse("500 ml")
+	sum, err := a.Add(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sum.String(); got != "1.5 l" {
+		t.Errorf("1 l + 500 ml = %v, want 1.5 l", got)
+	}
+
+	// small amounts are not rounded away
+	mg, _ := Parse("0.1 mg")
+	sum, err = mg.Add(mg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sum.String(); got != "0.2 mg" {
+		t.Errorf("0.1 mg + 0.1 mg = %v, want 0.2 mg", got)
+	}
+
+	c, _ := Parse("500 g")
+	if _, err := a.Add(c); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("1 l + 500 g did not fail with ErrIncompatible, %v", err)
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		q    Quantity
+		want string
+	}{
+		{Quantity{3, Piece}, "3"},
+		{Quantity{999, Gram}, "999 g"},
+		{Quantity{1250, Gram}, "1.25 kg"},
+		{Quantity{0.1 + 0.2, Milliliter}, "0.3 ml"},
+		{Quantity{0.0001, Gram}, "0.1 mg"},
+		{Quantity{0.25, Gram}, "250 mg"},
+		{Quantity{1, Pack}, "1 pack"},
+		{Quantity{2, Can}, "2 cans"},
+	}
+	for _, tt := range tests {
+		if got := tt.q.String(); got != tt.want {
+			t.Errorf("%#v.String() = %v, want %v", tt.q, got, tt.want)
+		}
+		// the text parses to the same quantity again
+		if q, err := Parse(tt.want); err != nil || q != (Quantity{round(tt.q.Amount), tt.q.Unit}) {
+			t.Errorf("Parse(%q) = %v, %v", tt.want, q, err)
+		}
+	}
+}
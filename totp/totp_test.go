@@ -0,0 +1,55 @@
+package totp
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// TestCode uses the SHA1 test vectors from RFC 6238, Appendix B.
+func TestCode(t *testing.T) {
+	key, _ := hex.DecodeString("3132333435363738393031323334353637383930")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		if got := code(key, Step(time.Unix(tt.unix, 0)), 8); got != tt.want {
+			t.Errorf("code at %v = %v, want %v", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c, err := Code(secret, Step(now)-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := Validate(secret, c, now, 0)
+	if !ok || step != Step(now)-1 {
+		t.Fatalf("valid code rejected")
+	}
+	if _, ok := Validate(secret, c, now, step); ok {
+		t.Errorf("code accepted twice")
+	}
+	if _, ok := Validate(secret, c, now.Add(3*Period*time.Second), 0); ok {
+		t.Errorf("outdated code accepted")
+	}
+	wrong := string('0'+(c[0]-'0'+1)%10) + c[1:]
+	if _, ok := Validate(secret, wrong, now, 0); ok {
+		t.Errorf("wrong code accepted")
+	}
+}